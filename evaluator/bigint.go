@@ -0,0 +1,217 @@
+package evaluator
+
+import (
+	"math"
+	"math/big"
+	"monkey/object"
+)
+
+// maxBigIntBits bounds the results of ** and <<, the two operators able to
+// build an enormous integer out of small operands in a single step.
+const maxBigIntBits = 1 << 20
+
+// evalBigIntInfixExpression evaluates an integer operation with arbitrary
+// precision. It is used when either operand is a BIGINT or when the int64
+// fast path overflows, and demotes the result to INTEGER when it fits.
+// The semantics match evalIntegerInfixExpression.
+func evalBigIntInfixExpression(
+	operator string,
+	left, right object.Object,
+) object.Object {
+	leftVal := toBigInt(left)
+	rightVal := toBigInt(right)
+
+	switch operator {
+	case "+":
+		return normalizeBigInt(new(big.Int).Add(leftVal, rightVal))
+	case "-":
+		return normalizeBigInt(new(big.Int).Sub(leftVal, rightVal))
+	case "*":
+		return normalizeBigInt(new(big.Int).Mul(leftVal, rightVal))
+	case "/", "%":
+		if rightVal.Sign() == 0 {
+			return newError("division by zero")
+		}
+		if operator == "/" {
+			return normalizeBigInt(new(big.Int).Quo(leftVal, rightVal))
+		}
+		return normalizeBigInt(new(big.Int).Rem(leftVal, rightVal))
+	case "**":
+		if rightVal.Sign() < 0 {
+			return &object.Float{Value: math.Pow(toFloat(left), toFloat(right))}
+		}
+		// 0, 1 and -1 never grow, whatever the exponent
+		if leftVal.CmpAbs(big.NewInt(1)) > 0 &&
+			(!rightVal.IsInt64() || rightVal.Int64() > maxBigIntBits ||
+				int64(leftVal.BitLen()-1)*rightVal.Int64() > maxBigIntBits) {
+			return newError("integer result too large")
+		}
+		return normalizeBigInt(new(big.Int).Exp(leftVal, rightVal, nil))
+	case "&":
+		return normalizeBigInt(new(big.Int).And(leftVal, rightVal))
+	case "|":
+		return normalizeBigInt(new(big.Int).Or(leftVal, rightVal))
+	case "^":
+		return normalizeBigInt(new(big.Int).Xor(leftVal, rightVal))
+	case "<<", ">>":
+		if rightVal.Sign() < 0 {
+			return newError("negative shift count: %s", rightVal)
+		}
+		if operator == ">>" {
+			if !rightVal.IsInt64() || rightVal.Int64() > int64(leftVal.BitLen()) {
+				if leftVal.Sign() < 0 {
+					return &object.Integer{Value: -1}
+				}
+				return &object.Integer{Value: 0}
+			}
+			return normalizeBigInt(new(big.Int).Rsh(leftVal, uint(rightVal.Int64())))
+		}
+		if leftVal.Sign() == 0 {
+			return &object.Integer{Value: 0}
+		}
+		if !rightVal.IsInt64() || rightVal.Int64() > maxBigIntBits ||
+			int64(leftVal.BitLen())+rightVal.Int64() > maxBigIntBits {
+			return newError("integer result too large")
+		}
+		return normalizeBigInt(new(big.Int).Lsh(leftVal, uint(rightVal.Int64())))
+	case "<":
+		return nativeBoolToBooleanObject(leftVal.Cmp(rightVal) < 0)
+	case ">":
+		return nativeBoolToBooleanObject(leftVal.Cmp(rightVal) > 0)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal.Cmp(rightVal) <= 0)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal.Cmp(rightVal) >= 0)
+	case "==":
+		return nativeBoolToBooleanObject(leftVal.Cmp(rightVal) == 0)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal.Cmp(rightVal) != 0)
+	default:
+		return newError("unknown operator: %s %s %s",
+			left.Type(), operator, right.Type())
+	}
+}
+
+// evalMixedComparison compares an integer with a float exactly. Converting
+// the integer to float64 instead would round it, so that distinct integers
+// compare equal to the same float, and turn those out of float64 range into
+// infinities.
+func evalMixedComparison(
+	operator string,
+	left, right object.Object,
+) object.Object {
+	leftVal, leftOk := toBigFloat(left)
+	rightVal, rightOk := toBigFloat(right)
+	if !leftOk || !rightOk {
+		// NaN is unordered, equal to nothing
+		return nativeBoolToBooleanObject(operator == "!=")
+	}
+
+	cmp := leftVal.Cmp(rightVal)
+	switch operator {
+	case "<":
+		return nativeBoolToBooleanObject(cmp < 0)
+	case ">":
+		return nativeBoolToBooleanObject(cmp > 0)
+	case "<=":
+		return nativeBoolToBooleanObject(cmp <= 0)
+	case ">=":
+		return nativeBoolToBooleanObject(cmp >= 0)
+	case "==":
+		return nativeBoolToBooleanObject(cmp == 0)
+	default:
+		return nativeBoolToBooleanObject(cmp != 0)
+	}
+}
+
+// toBigFloat converts a number to a big.Float without rounding. ±Inf are
+// kept as big.Float infinities; it reports false for NaN, which big.Float
+// cannot represent.
+func toBigFloat(obj object.Object) (*big.Float, bool) {
+	switch obj := obj.(type) {
+	case *object.Float:
+		if math.IsNaN(obj.Value) {
+			return nil, false
+		}
+		return new(big.Float).SetFloat64(obj.Value), true
+	default:
+		return new(big.Float).SetInt(toBigInt(obj)), true
+	}
+}
+
+// normalizeBigInt demotes value to an INTEGER when it fits in an int64.
+func normalizeBigInt(value *big.Int) object.Object {
+	if value.IsInt64() {
+		return &object.Integer{Value: value.Int64()}
+	}
+	return &object.BigInt{Value: value}
+}
+
+func isInteger(obj object.Object) bool {
+	return obj.Type() == object.INTEGER_OBJ || obj.Type() == object.BIGINT_OBJ
+}
+
+func toBigInt(obj object.Object) *big.Int {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return big.NewInt(obj.Value)
+	case *object.BigInt:
+		return obj.Value
+	default:
+		return new(big.Int)
+	}
+}
+
+// The int64 fast path helpers report false when the result overflows.
+
+func addInt64(a, b int64) (int64, bool) {
+	r := a + b
+	return r, (a^r)&(b^r) >= 0
+}
+
+func subInt64(a, b int64) (int64, bool) {
+	r := a - b
+	return r, (a^b)&(a^r) >= 0
+}
+
+func mulInt64(a, b int64) (int64, bool) {
+	if a == 0 || b == 0 {
+		return 0, true
+	}
+	r := a * b
+	if r/b != a || (a == -1 && b == math.MinInt64) || (b == -1 && a == math.MinInt64) {
+		return 0, false
+	}
+	return r, true
+}
+
+func shlInt64(a, b int64) (int64, bool) {
+	if a == 0 {
+		return 0, true
+	}
+	if b >= 64 {
+		return 0, false
+	}
+	r := a << uint64(b)
+	return r, r>>uint64(b) == a
+}
+
+// powInt64 computes base ** exp for exp >= 0 by repeated squaring.
+func powInt64(base, exp int64) (int64, bool) {
+	result := int64(1)
+	for exp > 0 {
+		var ok bool
+		if exp&1 == 1 {
+			if result, ok = mulInt64(result, base); !ok {
+				return 0, false
+			}
+		}
+		exp >>= 1
+		if exp > 0 {
+			if base, ok = mulInt64(base, base); !ok {
+				return 0, false
+			}
+		}
+	}
+	return result, true
+}
@@ -1,11 +1,19 @@
 package evaluator
 
 import (
+	"errors"
 	"fmt"
+	"math"
+	"math/big"
 	"monkey/object"
+	"strconv"
+	"strings"
+	"unicode/utf8"
 )
 
 var builtins = map[string]*object.Builtin{
+	// len counts the characters (runes) of a string, see bytelen for the
+	// size of its UTF-8 encoding.
 	"len": &object.Builtin{
 		Fn: func(args ...object.Object) object.Object {
 			if len(args) != 1 {
@@ -14,7 +22,7 @@ var builtins = map[string]*object.Builtin{
 
 			switch arg := args[0].(type) {
 			case *object.String:
-				return &object.Integer{Value: int64(len(arg.Value))}
+				return &object.Integer{Value: int64(utf8.RuneCountInString(arg.Value))}
 			case *object.Array:
 				return &object.Integer{Value: int64(len(arg.Elements))}
 			default:
@@ -23,6 +31,19 @@ var builtins = map[string]*object.Builtin{
 		},
 	},
 
+	"bytelen": &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			if args[0].Type() != object.STRING_OBJ {
+				return newError("argument to `bytelen` must be STRING, got=%s", args[0].Type())
+			}
+
+			return &object.Integer{Value: int64(len(args[0].(*object.String).Value))}
+		},
+	},
+
 	"first": &object.Builtin{
 		Fn: func(args ...object.Object) object.Object {
 			if len(args) != 1 {
@@ -61,6 +82,7 @@ var builtins = map[string]*object.Builtin{
 	},
 
 	"rest": &object.Builtin{
+		Allocates: true,
 		Fn: func(args ...object.Object) object.Object {
 			if len(args) != 1 {
 				return newError("wrong number of arguments. got=%d, want=1", len(args))
@@ -82,6 +104,7 @@ var builtins = map[string]*object.Builtin{
 	},
 
 	"push": &object.Builtin{
+		Allocates: true,
 		Fn: func(args ...object.Object) object.Object {
 			if len(args) != 2 {
 				return newError("wrong number of arguments. got=%d, want=2", len(args))
@@ -101,6 +124,97 @@ var builtins = map[string]*object.Builtin{
 		},
 	},
 
+	"int": &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+
+			switch arg := args[0].(type) {
+			case *object.Integer, *object.BigInt:
+				return arg
+			case *object.Float:
+				return floatToInteger("int", math.Trunc(arg.Value))
+			case *object.String:
+				literal := strings.TrimSpace(arg.Value)
+				value, err := strconv.ParseInt(literal, 0, 64)
+				if errors.Is(err, strconv.ErrRange) {
+					if value, ok := new(big.Int).SetString(literal, 0); ok {
+						return &object.BigInt{Value: value}
+					}
+				}
+				if err != nil {
+					return newError("could not convert %q to INTEGER", arg.Value)
+				}
+				return &object.Integer{Value: value}
+			default:
+				return newError("argument to `int` not supported, got %s", args[0].Type())
+			}
+		},
+	},
+
+	"float": &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+
+			switch arg := args[0].(type) {
+			case *object.Integer, *object.BigInt:
+				return &object.Float{Value: toFloat(arg)}
+			case *object.Float:
+				return arg
+			case *object.String:
+				value, err := strconv.ParseFloat(strings.TrimSpace(arg.Value), 64)
+				if err != nil {
+					return newError("could not convert %q to FLOAT", arg.Value)
+				}
+				return &object.Float{Value: value}
+			default:
+				return newError("argument to `float` not supported, got %s", args[0].Type())
+			}
+		},
+	},
+
+	// round rounds half away from zero, so round(2.5) is 3 and round(-2.5) is -3.
+	"round": roundingBuiltin("round", math.Round),
+	"floor": roundingBuiltin("floor", math.Floor),
+	"ceil":  roundingBuiltin("ceil", math.Ceil),
+
+	// range(stop), range(start, stop) or range(start, stop, step) counts
+	// from start, 0 by default, up to but not including stop.
+	"range": &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) < 1 || len(args) > 3 {
+				return newError("wrong number of arguments. got=%d, want=1 to 3", len(args))
+			}
+
+			bounds := make([]int64, len(args))
+			for i, arg := range args {
+				integer, ok := arg.(*object.Integer)
+				if !ok {
+					return newError("argument to `range` must be INTEGER, got=%s", arg.Type())
+				}
+				bounds[i] = integer.Value
+			}
+
+			r := &object.Range{Step: 1}
+			switch len(bounds) {
+			case 1:
+				r.Stop = bounds[0]
+			case 2:
+				r.Start, r.Stop = bounds[0], bounds[1]
+			case 3:
+				r.Start, r.Stop, r.Step = bounds[0], bounds[1], bounds[2]
+			}
+			if r.Step == 0 {
+				return newError("`range`: step must not be zero")
+			}
+
+			return r
+		},
+	},
+
 	"puts": &object.Builtin{
 		Fn: func(args ...object.Object) object.Object {
 			for _, arg := range args {
@@ -110,3 +224,38 @@ var builtins = map[string]*object.Builtin{
 		},
 	},
 }
+
+// roundingBuiltin returns a builtin rounding a number to an INTEGER with fn.
+func roundingBuiltin(name string, fn func(float64) float64) *object.Builtin {
+	return &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+
+			switch arg := args[0].(type) {
+			case *object.Integer, *object.BigInt:
+				return arg
+			case *object.Float:
+				return floatToInteger(name, fn(arg.Value))
+			default:
+				return newError("argument to `%s` must be INTEGER or FLOAT, got=%s",
+					name, args[0].Type())
+			}
+		},
+	}
+}
+
+// floatToInteger converts an integral float, giving a BIGINT when it is out
+// of the INTEGER range and failing for NaN and infinities.
+func floatToInteger(name string, value float64) object.Object {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return newError("`%s`: %s out of INTEGER range", name,
+			(&object.Float{Value: value}).Inspect())
+	}
+	if value < math.MinInt64 || value >= math.MaxInt64 {
+		result, _ := big.NewFloat(value).Int(nil)
+		return normalizeBigInt(result)
+	}
+	return &object.Integer{Value: int64(value)}
+}
@@ -1,139 +1,206 @@
 package evaluator
 
 import (
+	"context"
 	"fmt"
+	"math"
+	"math/big"
 	"monkey/ast"
 	"monkey/object"
+	"strings"
 )
 
 var (
 	NULL  = &object.Null{}
 	TRUE  = &object.Boolean{Value: true}
 	FALSE = &object.Boolean{Value: false}
+
+	BREAK    = &object.Break{}
+	CONTINUE = &object.Continue{}
 )
 
+// DefaultMaxCallDepth is the Limits.MaxCallDepth of evaluations started by
+// Eval, well below the depth at which the Go stack overflows and takes the
+// host down with it.
+const DefaultMaxCallDepth = 10000
+
+// Eval evaluates node in env with no limits other than DefaultMaxCallDepth,
+// see EvalContext.
 func Eval(node ast.Node, env *object.Environment) object.Object {
+	return EvalContext(context.Background(), node, env, Limits{MaxCallDepth: DefaultMaxCallDepth})
+}
+
+// eval evaluates node in env as part of the evaluation r.
+func eval(node ast.Node, env *object.Environment, r *run) object.Object {
 	switch node := node.(type) {
 
 	case *ast.Program:
-		return evalProgram(node, env)
+		return evalProgram(node, env, r)
 
 	case *ast.BlockStatement:
-		return evalBlockStatement(node, env)
+		return evalBlockStatement(node, env, r)
 
 	case *ast.ExpressionStatement:
-		return Eval(node.Expression, env)
+		return eval(node.Expression, env, r)
 
 	case *ast.HashLiteral:
-		return evalHashLiteral(node, env)
+		return allocate(node, r, evalHashLiteral(node, env, r))
 
 	case *ast.ArrayLiteral:
-		elements := evalExpressions(node.Elements, env)
-		if len(elements) == 1 && isError(elements[0]) {
+		elements := evalExpressions(node.Elements, env, r)
+		if len(elements) == 1 && isAbrupt(elements[0]) {
 			return elements[0]
 		}
-		return &object.Array{Elements: elements}
+		return allocate(node, r, &object.Array{Elements: elements})
 
 	case *ast.IndexExpression:
-		left := Eval(node.Left, env)
-		if isError(left) {
+		left := eval(node.Left, env, r)
+		if isAbrupt(left) {
 			return left
 		}
-		index := Eval(node.Index, env)
-		if isError(index) {
+		index := eval(node.Index, env, r)
+		if isAbrupt(index) {
 			return index
 		}
-		return evalIndexExpression(left, index)
+		return errorAt(node, evalIndexExpression(left, index))
 
 	case *ast.ReturnStatement:
-		val := Eval(node.ReturnValue, env)
-		if isError(val) {
+		val := eval(node.ReturnValue, env, r)
+		if isAbrupt(val) {
 			return val
 		}
 		return &object.ReturnValue{Value: val}
 
+	case *ast.FunctionStatement:
+		// the body looks the name up when called, by then it is bound
+		env.Set(node.Name.Value, eval(node.Function, env, r))
+
+	case *ast.WhileStatement:
+		return evalWhileStatement(node, env, r)
+
+	case *ast.ForStatement:
+		return evalForStatement(node, env, r)
+
+	case *ast.BreakStatement:
+		return BREAK
+
+	case *ast.ContinueStatement:
+		return CONTINUE
+
+	case *ast.BadStatement:
+		return errorAt(node, newError("cannot evaluate statement with syntax errors"))
+
 	case *ast.LetStatement:
-		val := Eval(node.Value, env)
-		if isError(val) {
+		val := eval(node.Value, env, r)
+		if isAbrupt(val) {
 			return val
 		}
+		if node.Pattern != nil {
+			return evalDestructuringLet(node, val, env, r)
+		}
 		env.Set(node.Name.Value, val)
 
 	// Expressions
 	case *ast.IntegerLiteral:
+		if node.Big != nil {
+			return &object.BigInt{Value: node.Big}
+		}
 		return &object.Integer{Value: node.Value}
 
+	case *ast.FloatLiteral:
+		return &object.Float{Value: node.Value}
+
 	case *ast.Boolean:
 		return nativeBoolToBooleanObject(node.Value)
 
 	case *ast.StringLiteral:
-		return &object.String{Value: node.Value}
+		return allocate(node, r, &object.String{Value: node.Value})
 
 	case *ast.PrefixExpression:
-		right := Eval(node.Right, env)
-		if isError(right) {
+		right := eval(node.Right, env, r)
+		if isAbrupt(right) {
 			return right
 		}
-		return evalPrefixExpression(node.Operator, right)
+		return errorAt(node, evalPrefixExpression(node.Operator, right))
 
 	case *ast.InfixExpression:
-		left := Eval(node.Left, env)
-		if isError(left) {
+		if node.Operator == "&&" || node.Operator == "||" {
+			return evalLogicalExpression(node, env, r)
+		}
+
+		left := eval(node.Left, env, r)
+		if isAbrupt(left) {
 			return left
 		}
 
-		right := Eval(node.Right, env)
-		if isError(right) {
+		right := eval(node.Right, env, r)
+		if isAbrupt(right) {
 			return right
 		}
 
-		return evalInfixExpression(node.Operator, left, right)
+		return allocate(node, r, errorAt(node, evalInfixExpression(node.Operator, left, right)))
+
+	case *ast.AssignExpression:
+		return evalAssignExpression(node, env, r)
 
 	case *ast.IfExpression:
-		return evalIfExpression(node, env)
+		return evalIfExpression(node, env, r)
+
+	case *ast.MatchExpression:
+		return evalMatchExpression(node, env, r)
 
 	case *ast.Identifier:
 		return evalIdentifier(node, env)
 
 	case *ast.FunctionLiteral:
-		params := node.Parameters
-		body := node.Body
-		return &object.Function{Parameters: params, Env: env, Body: body}
+		return &object.Function{
+			Name:       node.Name,
+			Parameters: node.Parameters,
+			Defaults:   node.Defaults,
+			Rest:       node.Rest,
+			Env:        env,
+			Body:       node.Body,
+		}
 
 	case *ast.CallExpression:
-		function := Eval(node.Function, env)
-		if isError(function) {
+		function := eval(node.Function, env, r)
+		if isAbrupt(function) {
 			return function
 		}
 
-		args := evalExpressions(node.Arguments, env)
-		if len(args) == 1 && isError(args[0]) {
+		args := evalExpressions(node.Arguments, env, r)
+		if len(args) == 1 && isAbrupt(args[0]) {
 			return args[0]
 		}
 
-		return applyFunction(function, args)
+		if node.Tail {
+			// applyFunction of the enclosing call runs it
+			return &object.TailCall{Function: function, Arguments: args, Call: node}
+		}
+
+		return pushFrame(node, function, applyFunction(function, args, r))
 	}
 
 	return nil
 }
 
-func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Object {
+func evalHashLiteral(node *ast.HashLiteral, env *object.Environment, r *run) object.Object {
 	pairs := make(map[object.HashKey]object.HashPair)
 
 	for keyNode, valueNode := range node.Pairs {
-		key := Eval(keyNode, env)
-		if isError(key) {
+		key := eval(keyNode, env, r)
+		if isAbrupt(key) {
 			return key
 		}
 
 		hashKey, ok := key.(object.Hashable)
 
 		if !ok {
-			return newError("unusable as hash key: %s", key.Type())
+			return errorAt(keyNode, newError("unusable as hash key: %s", key.Type()))
 		}
 
-		value := Eval(valueNode, env)
-		if isError(value) {
+		value := eval(valueNode, env, r)
+		if isAbrupt(value) {
 			return value
 		}
 
@@ -164,6 +231,9 @@ func evalIndexExpression(left, index object.Object) object.Object {
 	switch {
 	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
 		return evalArrayIndexExpression(left, index)
+	case left.Type() == object.ARRAY_OBJ && index.Type() == object.BIGINT_OBJ:
+		// always out of range
+		return NULL
 	case left.Type() == object.HASH_OBJ:
 		return evalHashIndexExpression(left, index)
 	default:
@@ -183,17 +253,28 @@ func evalArrayIndexExpression(array, index object.Object) object.Object {
 	return arrayObject.Elements[idx]
 }
 
-func evalProgram(program *ast.Program, env *object.Environment) object.Object {
-	var result object.Object
+// evalProgram recovers Go panics escaping from a statement, turning them
+// into internal errors at that statement. EvalContext catches the ones
+// raised anywhere else.
+func evalProgram(program *ast.Program, env *object.Environment, r *run) (result object.Object) {
+	var current ast.Node = program
+	defer func() {
+		if r := recover(); r != nil {
+			result = errorAt(current, newInternalError(r))
+		}
+	}()
 
 	for _, statement := range program.Statements {
-		result = Eval(statement, env)
+		current = statement
+		result = eval(statement, env, r)
 
 		switch result := result.(type) {
 		case *object.ReturnValue:
 			return result.Value
 		case *object.Error:
 			return result
+		case *object.Break, *object.Continue:
+			return errorAt(current, newError("%s outside loop", result.Inspect()))
 		}
 	}
 
@@ -203,15 +284,16 @@ func evalProgram(program *ast.Program, env *object.Environment) object.Object {
 func evalBlockStatement(
 	block *ast.BlockStatement,
 	env *object.Environment,
+	r *run,
 ) object.Object {
 	var result object.Object
 
 	for _, statement := range block.Statements {
-		result = Eval(statement, env)
+		result = eval(statement, env, r)
 
 		if result != nil {
-			rt := result.Type()
-			if rt == object.RETURN_VALUE_OBJ || rt == object.ERROR_OBJ {
+			switch result.Type() {
+			case object.RETURN_VALUE_OBJ, object.ERROR_OBJ, object.BREAK_OBJ, object.CONTINUE_OBJ:
 				return result
 			}
 		}
@@ -220,6 +302,120 @@ func evalBlockStatement(
 	return result
 }
 
+func evalWhileStatement(
+	ws *ast.WhileStatement,
+	env *object.Environment,
+	r *run,
+) object.Object {
+	for {
+		condition := eval(ws.Condition, env, r)
+		if isAbrupt(condition) {
+			return condition
+		}
+		if !isTruthy(condition) {
+			return NULL
+		}
+
+		if result, stop := evalLoopBody(ws.Body, env, r); stop {
+			return result
+		}
+	}
+}
+
+// evalForStatement iterates over the elements of an array, the keys of a
+// hash in no particular order, the characters of a string or the integers
+// of a range. Each iteration gets its own scope holding the loop variable.
+func evalForStatement(
+	fs *ast.ForStatement,
+	env *object.Environment,
+	r *run,
+) object.Object {
+	iterable := eval(fs.Iterable, env, r)
+	if isAbrupt(iterable) {
+		return iterable
+	}
+
+	iterate := func(element object.Object) (object.Object, bool) {
+		loopEnv := object.NewEnclosedEnvironment(env)
+		loopEnv.Set(fs.Variable.Value, element)
+		return evalLoopBody(fs.Body, loopEnv, r)
+	}
+
+	switch iterable := iterable.(type) {
+	case *object.Array:
+		// index assignment in the body may change elements, but not the length
+		for i := 0; i < len(iterable.Elements); i++ {
+			if result, stop := iterate(iterable.Elements[i]); stop {
+				return result
+			}
+		}
+	case *object.Hash:
+		keys := make([]object.Object, 0, len(iterable.Pairs))
+		for _, pair := range iterable.Pairs {
+			keys = append(keys, pair.Key)
+		}
+		for _, key := range keys {
+			if result, stop := iterate(key); stop {
+				return result
+			}
+		}
+	case *object.String:
+		for _, ch := range iterable.Value {
+			char := allocate(fs.Iterable, r, &object.String{Value: string(ch)})
+			if isError(char) {
+				return char
+			}
+			if result, stop := iterate(char); stop {
+				return result
+			}
+		}
+	case *object.Range:
+		i := iterable.Start
+		for (iterable.Step > 0 && i < iterable.Stop) || (iterable.Step < 0 && i > iterable.Stop) {
+			if result, stop := iterate(&object.Integer{Value: i}); stop {
+				return result
+			}
+			next, ok := addInt64(i, iterable.Step)
+			if !ok {
+				// the next element overflows, so it is past Stop anyway
+				break
+			}
+			i = next
+		}
+	default:
+		return errorAt(fs.Iterable, newError("cannot iterate over %s", iterable.Type()))
+	}
+
+	return NULL
+}
+
+// evalLoopBody runs one iteration of a loop and reports whether the loop
+// must stop, with the object to stop with: a return value or an error to
+// pass on, or NULL after a break.
+func evalLoopBody(
+	body *ast.BlockStatement,
+	env *object.Environment,
+	r *run,
+) (object.Object, bool) {
+	if err := r.step(); err != nil {
+		return errorAt(body, err), true
+	}
+
+	result := eval(body, env, r)
+	if result == nil {
+		return nil, false
+	}
+
+	switch result.Type() {
+	case object.RETURN_VALUE_OBJ, object.ERROR_OBJ:
+		return result, true
+	case object.BREAK_OBJ:
+		return NULL, true
+	default:
+		return nil, false
+	}
+}
+
 func nativeBoolToBooleanObject(input bool) *object.Boolean {
 	if input {
 		return TRUE
@@ -233,6 +429,8 @@ func evalPrefixExpression(operator string, right object.Object) object.Object {
 		return evalBangOperatorExpression(right)
 	case "-":
 		return evalMinusPrefixOperatorExpression(right)
+	case "~":
+		return evalTildePrefixOperatorExpression(right)
 	default:
 		return newError("unknown operator: %s%s", operator, right.Type())
 	}
@@ -245,12 +443,16 @@ func evalInfixExpression(
 	switch {
 	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
 		return evalIntegerInfixExpression(operator, left, right)
+	case isInteger(left) && isInteger(right):
+		return evalBigIntInfixExpression(operator, left, right)
+	case isNumber(left) && isNumber(right):
+		return evalFloatInfixExpression(operator, left, right)
+	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
+		return evalStringInfixExpression(operator, left, right)
 	case operator == "==":
 		return nativeBoolToBooleanObject(left == right)
 	case operator == "!=":
 		return nativeBoolToBooleanObject(left != right)
-	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
-		return evalStringInfixExpression(operator, left, right)
 	case left.Type() != right.Type():
 		return newError("type mismatch: %s %s %s",
 			left.Type(), operator, right.Type())
@@ -274,14 +476,38 @@ func evalBangOperatorExpression(right object.Object) object.Object {
 }
 
 func evalMinusPrefixOperatorExpression(right object.Object) object.Object {
-	if right.Type() != object.INTEGER_OBJ {
+	switch right := right.(type) {
+	case *object.Integer:
+		if right.Value == math.MinInt64 {
+			return normalizeBigInt(new(big.Int).Neg(big.NewInt(right.Value)))
+		}
+		return &object.Integer{Value: -right.Value}
+	case *object.Float:
+		return &object.Float{Value: -right.Value}
+	case *object.BigInt:
+		return normalizeBigInt(new(big.Int).Neg(right.Value))
+	default:
 		return newError("unknown operator: -%s", right.Type())
 	}
+}
 
-	value := right.(*object.Integer).Value
-	return &object.Integer{Value: -value}
+func evalTildePrefixOperatorExpression(right object.Object) object.Object {
+	switch right := right.(type) {
+	case *object.Integer:
+		return &object.Integer{Value: ^right.Value}
+	case *object.BigInt:
+		return normalizeBigInt(new(big.Int).Not(right.Value))
+	default:
+		return newError("unknown operator: ~%s", right.Type())
+	}
 }
 
+// evalIntegerInfixExpression promotes to a BIGINT when the result does not
+// fit in an int64. Division and modulo by zero are errors. % truncates like
+// /, so the result has the sign of the dividend (-7 % 3 is -1). ** with a
+// negative exponent gives a FLOAT. Shifts are arithmetic: shifting right by
+// 64 or more gives 0, or -1 for a negative number, and negative counts are
+// an error.
 func evalIntegerInfixExpression(
 	operator string,
 	left, right object.Object,
@@ -291,17 +517,112 @@ func evalIntegerInfixExpression(
 
 	switch operator {
 	case "+":
-		return &object.Integer{Value: leftVal + rightVal}
+		if sum, ok := addInt64(leftVal, rightVal); ok {
+			return &object.Integer{Value: sum}
+		}
+		return evalBigIntInfixExpression(operator, left, right)
 	case "-":
-		return &object.Integer{Value: leftVal - rightVal}
+		if diff, ok := subInt64(leftVal, rightVal); ok {
+			return &object.Integer{Value: diff}
+		}
+		return evalBigIntInfixExpression(operator, left, right)
 	case "*":
-		return &object.Integer{Value: leftVal * rightVal}
+		if product, ok := mulInt64(leftVal, rightVal); ok {
+			return &object.Integer{Value: product}
+		}
+		return evalBigIntInfixExpression(operator, left, right)
+	case "/", "%":
+		if rightVal == 0 {
+			return newError("division by zero")
+		}
+		if leftVal == math.MinInt64 && rightVal == -1 {
+			return evalBigIntInfixExpression(operator, left, right)
+		}
+		if operator == "/" {
+			return &object.Integer{Value: leftVal / rightVal}
+		}
+		return &object.Integer{Value: leftVal % rightVal}
+	case "**":
+		if rightVal < 0 {
+			return &object.Float{Value: math.Pow(float64(leftVal), float64(rightVal))}
+		}
+		if power, ok := powInt64(leftVal, rightVal); ok {
+			return &object.Integer{Value: power}
+		}
+		return evalBigIntInfixExpression(operator, left, right)
+	case "&":
+		return &object.Integer{Value: leftVal & rightVal}
+	case "|":
+		return &object.Integer{Value: leftVal | rightVal}
+	case "^":
+		return &object.Integer{Value: leftVal ^ rightVal}
+	case "<<", ">>":
+		if rightVal < 0 {
+			return newError("negative shift count: %d", rightVal)
+		}
+		if operator == "<<" {
+			if shifted, ok := shlInt64(leftVal, rightVal); ok {
+				return &object.Integer{Value: shifted}
+			}
+			return evalBigIntInfixExpression(operator, left, right)
+		}
+		return &object.Integer{Value: leftVal >> uint64(rightVal)}
+	case "<":
+		return nativeBoolToBooleanObject(leftVal < rightVal)
+	case ">":
+		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
+	case "==":
+		return nativeBoolToBooleanObject(leftVal == rightVal)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal != rightVal)
+	default:
+		return newError("unknown operator: %s %s %s",
+			left.Type(), operator, right.Type())
+	}
+}
+
+// evalFloatInfixExpression evaluates arithmetic and comparisons between
+// two numbers where at least one is a float, the other is converted.
+// Division by zero follows IEEE 754, 1 / 0.0 is +Inf.
+func evalFloatInfixExpression(
+	operator string,
+	left, right object.Object,
+) object.Object {
+	if isInteger(left) || isInteger(right) {
+		switch operator {
+		case "<", ">", "<=", ">=", "==", "!=":
+			return evalMixedComparison(operator, left, right)
+		}
+	}
+
+	leftVal := toFloat(left)
+	rightVal := toFloat(right)
+
+	switch operator {
+	case "+":
+		return &object.Float{Value: leftVal + rightVal}
+	case "-":
+		return &object.Float{Value: leftVal - rightVal}
+	case "*":
+		return &object.Float{Value: leftVal * rightVal}
 	case "/":
-		return &object.Integer{Value: leftVal / rightVal}
+		return &object.Float{Value: leftVal / rightVal}
+	case "%":
+		return &object.Float{Value: math.Mod(leftVal, rightVal)}
+	case "**":
+		return &object.Float{Value: math.Pow(leftVal, rightVal)}
 	case "<":
 		return nativeBoolToBooleanObject(leftVal < rightVal)
 	case ">":
 		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
 	case "==":
 		return nativeBoolToBooleanObject(leftVal == rightVal)
 	case "!=":
@@ -312,24 +633,333 @@ func evalIntegerInfixExpression(
 	}
 }
 
+func isNumber(obj object.Object) bool {
+	return isInteger(obj) || obj.Type() == object.FLOAT_OBJ
+}
+
+func toFloat(obj object.Object) float64 {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return float64(obj.Value)
+	case *object.BigInt:
+		f, _ := new(big.Float).SetInt(obj.Value).Float64()
+		return f
+	case *object.Float:
+		return obj.Value
+	default:
+		return 0
+	}
+}
+
+// evalLogicalExpression short-circuits && and ||, returning the operand that
+// decided the result rather than a boolean: `0 || "x"` is 0, `null || "x"`
+// is "x".
+func evalLogicalExpression(
+	node *ast.InfixExpression,
+	env *object.Environment,
+	r *run,
+) object.Object {
+	left := eval(node.Left, env, r)
+	if isAbrupt(left) {
+		return left
+	}
+
+	if node.Operator == "&&" && !isTruthy(left) {
+		return left
+	}
+	if node.Operator == "||" && isTruthy(left) {
+		return left
+	}
+
+	return eval(node.Right, env, r)
+}
+
+// evalMatchExpression tries the arms in order. The bindings of the matching
+// arm are only visible in its body.
+func evalMatchExpression(
+	me *ast.MatchExpression,
+	env *object.Environment,
+	r *run,
+) object.Object {
+	subject := eval(me.Subject, env, r)
+	if isAbrupt(subject) {
+		return subject
+	}
+
+	for _, arm := range me.Arms {
+		armEnv := object.NewEnclosedEnvironment(env)
+
+		mismatch, err := matchPattern(arm.Pattern, subject, armEnv, r)
+		if err != nil {
+			return err
+		}
+		if mismatch == nil {
+			return eval(arm.Body, armEnv, r)
+		}
+	}
+
+	return errorAt(me, newError("no match for %s", subject.Inspect()))
+}
+
+// evalDestructuringLet binds the names of a let pattern. Unlike in a match
+// there is no other arm to try, so a value of the wrong shape is an error.
+func evalDestructuringLet(
+	ls *ast.LetStatement,
+	val object.Object,
+	env *object.Environment,
+	r *run,
+) object.Object {
+	mismatch, err := matchPattern(ls.Pattern, val, env, r)
+	if err != nil {
+		return err
+	}
+	if mismatch != nil {
+		return errorAt(mismatch.pattern, newError("cannot destructure: %s", mismatch.reason))
+	}
+	return nil
+}
+
+// patternMismatch describes why a value does not match a pattern.
+type patternMismatch struct {
+	pattern ast.Pattern // the innermost pattern that failed
+	reason  string
+}
+
+// matchPattern binds the parts of value matched by pattern in env and
+// returns nil, or the reason it does not match, leaving env with partial
+// bindings. The error is set when evaluating a literal fails.
+func matchPattern(
+	pattern ast.Pattern,
+	value object.Object,
+	env *object.Environment,
+	r *run,
+) (*patternMismatch, object.Object) {
+	mismatch := func(format string, a ...interface{}) (*patternMismatch, object.Object) {
+		return &patternMismatch{pattern: pattern, reason: fmt.Sprintf(format, a...)}, nil
+	}
+
+	switch pattern := pattern.(type) {
+	case *ast.WildcardPattern:
+		return nil, nil
+
+	case *ast.BindingPattern:
+		env.Set(pattern.Name.Value, value)
+		return nil, nil
+
+	case *ast.LiteralPattern:
+		literal := eval(pattern.Value, env, r)
+		if isError(literal) {
+			return nil, literal
+		}
+		if evalInfixExpression("==", literal, value) != TRUE {
+			return mismatch("expected %s, got %s", literal.Inspect(), value.Inspect())
+		}
+		return nil, nil
+
+	case *ast.ArrayPattern:
+		array, ok := value.(*object.Array)
+		if !ok {
+			return mismatch("expected ARRAY, got %s", value.Type())
+		}
+		if pattern.Rest == nil && len(array.Elements) != len(pattern.Elements) {
+			return mismatch("expected %d elements, got %d",
+				len(pattern.Elements), len(array.Elements))
+		}
+		if len(array.Elements) < len(pattern.Elements) {
+			return mismatch("expected at least %d elements, got %d",
+				len(pattern.Elements), len(array.Elements))
+		}
+		for i, element := range pattern.Elements {
+			failed, err := matchPattern(element, array.Elements[i], env, r)
+			if failed != nil || err != nil {
+				return failed, err
+			}
+		}
+		if pattern.Rest != nil {
+			rest := make([]object.Object, len(array.Elements)-len(pattern.Elements))
+			copy(rest, array.Elements[len(pattern.Elements):])
+			restArray := allocate(pattern.Rest, r, &object.Array{Elements: rest})
+			if isError(restArray) {
+				return nil, restArray
+			}
+			env.Set(pattern.Rest.Value, restArray)
+		}
+		return nil, nil
+
+	case *ast.HashPattern:
+		hash, ok := value.(*object.Hash)
+		if !ok {
+			return mismatch("expected HASH, got %s", value.Type())
+		}
+		for _, pair := range pattern.Pairs {
+			key := eval(pair.Key.Value, env, r)
+			if isError(key) {
+				return nil, key
+			}
+			hashKey, ok := key.(object.Hashable)
+			if !ok {
+				return nil, errorAt(pair.Key, newError("unusable as hash key: %s", key.Type()))
+			}
+			entry, ok := hash.Pairs[hashKey.HashKey()]
+			if !ok {
+				return mismatch("key not found: %s", key.Inspect())
+			}
+			failed, err := matchPattern(pair.Value, entry.Value, env, r)
+			if failed != nil || err != nil {
+				return failed, err
+			}
+		}
+		return nil, nil
+
+	default:
+		return nil, errorAt(pattern, newError("unknown pattern: %T", pattern))
+	}
+}
+
 func evalIfExpression(
 	ie *ast.IfExpression,
 	env *object.Environment,
+	r *run,
 ) object.Object {
-	condition := Eval(ie.Condition, env)
-	if isError(condition) {
+	condition := eval(ie.Condition, env, r)
+	if isAbrupt(condition) {
 		return condition
 	}
 
 	if isTruthy(condition) {
-		return Eval(ie.Consequence, env)
+		return eval(ie.Consequence, env, r)
 	} else if ie.Alternative != nil {
-		return Eval(ie.Alternative, env)
+		return eval(ie.Alternative, env, r)
 	} else {
 		return NULL
 	}
 }
 
+// evalAssignExpression rebinds an existing variable, or stores into an
+// array element or hash entry, and returns the new value. Compound forms
+// apply their operator to the current value first, so `x += 1` behaves like
+// `x = x + 1`.
+func evalAssignExpression(
+	node *ast.AssignExpression,
+	env *object.Environment,
+	r *run,
+) object.Object {
+	if target, ok := node.Target.(*ast.IndexExpression); ok {
+		return evalIndexAssignExpression(node, target, env, r)
+	}
+
+	name := node.Target.(*ast.Identifier)
+
+	val := eval(node.Value, env, r)
+	if isAbrupt(val) {
+		return val
+	}
+
+	if node.Operator != "=" {
+		current, ok := env.Get(name.Value)
+		if !ok {
+			return errorAt(name, newError("identifier not found: "+name.Value))
+		}
+		val = evalCompoundAssignment(node, current, val, r)
+		if isError(val) {
+			return val
+		}
+	}
+
+	if !env.Assign(name.Value, val) {
+		return errorAt(name, newError("cannot assign to undeclared identifier: %s", name.Value))
+	}
+
+	return val
+}
+
+// evalIndexAssignExpression evaluates the collection, then the index, then
+// the value, and updates the collection in place: every reference to the
+// same array or hash sees the change.
+func evalIndexAssignExpression(
+	node *ast.AssignExpression,
+	target *ast.IndexExpression,
+	env *object.Environment,
+	r *run,
+) object.Object {
+	left := eval(target.Left, env, r)
+	if isAbrupt(left) {
+		return left
+	}
+
+	index := eval(target.Index, env, r)
+	if isAbrupt(index) {
+		return index
+	}
+
+	val := eval(node.Value, env, r)
+	if isAbrupt(val) {
+		return val
+	}
+
+	switch collection := left.(type) {
+	case *object.Array:
+		if !isInteger(index) {
+			return errorAt(target.Index, newError("array index must be INTEGER, got %s", index.Type()))
+		}
+		idx, ok := index.(*object.Integer)
+		if !ok || idx.Value < 0 || idx.Value >= int64(len(collection.Elements)) {
+			return errorAt(target, newError("index out of range: %s (array length %d)",
+				index.Inspect(), len(collection.Elements)))
+		}
+
+		if node.Operator != "=" {
+			val = evalCompoundAssignment(node, collection.Elements[idx.Value], val, r)
+			if isError(val) {
+				return val
+			}
+		}
+
+		collection.Elements[idx.Value] = val
+
+	case *object.Hash:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return errorAt(target.Index, newError("unusable as hash key: %s", index.Type()))
+		}
+		hashed := key.HashKey()
+
+		if node.Operator != "=" {
+			pair, ok := collection.Pairs[hashed]
+			if !ok {
+				return errorAt(target, newError("key not found: %s", index.Inspect()))
+			}
+			val = evalCompoundAssignment(node, pair.Value, val, r)
+			if isError(val) {
+				return val
+			}
+		}
+
+		if _, ok := collection.Pairs[hashed]; !ok {
+			if err := r.charge(pairSize); err != nil {
+				return errorAt(target, err)
+			}
+		}
+		collection.Pairs[hashed] = object.HashPair{Key: index, Value: val}
+
+	default:
+		return errorAt(target, newError("index assignment not supported: %s", left.Type()))
+	}
+
+	return val
+}
+
+// evalCompoundAssignment applies the operator of a compound assignment,
+// the + of +=, to the current value and the assigned one.
+func evalCompoundAssignment(
+	node *ast.AssignExpression,
+	current, val object.Object,
+	r *run,
+) object.Object {
+	operator := strings.TrimSuffix(node.Operator, "=")
+	return allocate(node, r, errorAt(node, evalInfixExpression(operator, current, val)))
+}
+
 func evalIdentifier(
 	node *ast.Identifier,
 	env *object.Environment,
@@ -348,7 +978,7 @@ func evalIdentifier(
 		return builtin
 	}
 
-	return newError("identifier not found: " + node.Value)
+	return errorAt(node, newError("identifier not found: "+node.Value))
 }
 
 func isTruthy(obj object.Object) bool {
@@ -368,6 +998,62 @@ func newError(format string, a ...interface{}) *object.Error {
 	return &object.Error{Message: fmt.Sprintf(format, a...)}
 }
 
+// errorAt stamps obj with the position of node if obj is an error raised
+// without one. Errors coming from deeper nodes keep their position.
+func errorAt(node ast.Node, obj object.Object) object.Object {
+	if err, ok := obj.(*object.Error); ok && !err.Pos.IsValid() {
+		err.Pos = node.Pos()
+	}
+	return obj
+}
+
+// pushFrame adds call to the stack of an error raised while running the
+// body of fn, a Monkey function. Errors raised by the call itself, such as
+// a wrong number of arguments, get the position of the call site instead.
+// Tail calls replace the frame of their caller, so only the last one of a
+// chain of tail calls appears.
+func pushFrame(call *ast.CallExpression, fn object.Object, obj object.Object) object.Object {
+	errObj, ok := obj.(*object.Error)
+	if !ok {
+		return obj
+	}
+	if !errObj.Pos.IsValid() {
+		errObj.Pos = call.Pos()
+		return errObj
+	}
+
+	if function, ok := fn.(*object.Function); ok {
+		errObj.Stack = append(errObj.Stack, object.Frame{Function: function.Name, Pos: call.Pos()})
+	}
+	return errObj
+}
+
+func newInternalError(r interface{}) *object.Error {
+	return newError("internal error: %v", r)
+}
+
+// allocate charges obj against the memory quota of r, see run.allocate,
+// stamping a quota error with the position of node.
+func allocate(node ast.Node, r *run, obj object.Object) object.Object {
+	return errorAt(node, r.allocate(obj))
+}
+
+// isAbrupt reports whether obj cuts short the evaluation of the expression
+// it is a part of: an error, or a return, break or continue coming out of a
+// block in value position, as in `let y = if (c) { break; };`. Such objects
+// are passed up unchanged, like errors.
+func isAbrupt(obj object.Object) bool {
+	if obj == nil {
+		return false
+	}
+	switch obj.Type() {
+	case object.ERROR_OBJ, object.RETURN_VALUE_OBJ, object.BREAK_OBJ, object.CONTINUE_OBJ:
+		return true
+	default:
+		return false
+	}
+}
+
 func isError(obj object.Object) bool {
 	if obj != nil {
 		return obj.Type() == object.ERROR_OBJ
@@ -378,12 +1064,13 @@ func isError(obj object.Object) bool {
 func evalExpressions(
 	exps []ast.Expression,
 	env *object.Environment,
+	r *run,
 ) []object.Object {
 	var result []object.Object
 
 	for _, e := range exps {
-		evaluated := Eval(e, env)
-		if isError(evaluated) {
+		evaluated := eval(e, env, r)
+		if isAbrupt(evaluated) {
 			return []object.Object{evaluated}
 		}
 		result = append(result, evaluated)
@@ -392,7 +1079,33 @@ func evalExpressions(
 	return result
 }
 
-func applyFunction(fn object.Object, args []object.Object) object.Object {
+// applyFunction recovers Go panics raised while running fn, turning them
+// into internal errors that the caller stamps with the call site.
+//
+// It is also the trampoline for tail calls: when the body of fn ends with a
+// call in tail position, that call comes back as an object.TailCall and is
+// run by the loop here instead of by a nested Eval, so tail recursion runs
+// in constant Go stack. Errors of a tail call get the position of the tail
+// call rather than of the original call site.
+func applyFunction(
+	fn object.Object,
+	args []object.Object,
+	r *run,
+) (result object.Object) {
+	if r == nil {
+		r = &run{ctx: context.Background(), limits: Limits{MaxCallDepth: DefaultMaxCallDepth}}
+	}
+	if limit := r.limits.MaxCallDepth; limit > 0 && r.depth >= limit {
+		return newError("maximum recursion depth exceeded (limit %d)", limit)
+	}
+	r.depth++
+	defer func() {
+		r.depth--
+		if r := recover(); r != nil {
+			result = newInternalError(r)
+		}
+	}()
+
 	// function, ok := fn.(*object.Function)
 	// if !ok {
 	// 	return newError("not a function: %s", fn.Type())
@@ -402,45 +1115,158 @@ func applyFunction(fn object.Object, args []object.Object) object.Object {
 	// evaluated := Eval(function.Body, extendedEnv)
 	// return unwrapReturnValue(evaluated)
 
+	var tailCall *object.TailCall
+	for {
+		if err := r.step(); err != nil {
+			return err
+		}
+
+		result = callFunction(fn, args, r)
+
+		if tailCall != nil {
+			result = pushFrame(tailCall.Call, fn, result)
+		}
+
+		next, ok := result.(*object.TailCall)
+		if !ok {
+			return result
+		}
+		tailCall = next
+		fn, args = tailCall.Function, tailCall.Arguments
+	}
+}
+
+// callFunction runs a single call, which may end in a tail call.
+func callFunction(fn object.Object, args []object.Object, r *run) object.Object {
 	switch fn := fn.(type) {
 	case *object.Function:
-		extendedEnv := extendFunctionEnv(fn, args)
-		evaluated := Eval(fn.Body, extendedEnv)
+		extendedEnv, err := extendFunctionEnv(fn, args, r)
+		if err != nil {
+			return err
+		}
+		evaluated := eval(fn.Body, extendedEnv, r)
 		return unwrapReturnValue(evaluated)
 	case *object.Builtin:
-		return fn.Fn(args...)
+		result := fn.Fn(args...)
+		if !fn.Allocates {
+			return result
+		}
+		return r.allocate(result)
 	default:
 		return newError("not a function: %s", fn.Type())
 	}
 }
 
+// extendFunctionEnv binds the arguments of a call after checking their
+// number. Missing trailing arguments take their default values, evaluated
+// in order in the new environment so they can refer to earlier parameters,
+// and extra ones are collected into the rest parameter.
 func extendFunctionEnv(
 	fn *object.Function,
 	args []object.Object,
-) *object.Environment {
+	r *run,
+) (*object.Environment, object.Object) {
+	if err := checkArity(fn, len(args)); err != nil {
+		return nil, err
+	}
+
 	env := object.NewEnclosedEnvironment(fn.Env)
 
 	for paramIdx, param := range fn.Parameters {
-		env.Set(param.Value, args[paramIdx])
+		if paramIdx < len(args) {
+			env.Set(param.Value, args[paramIdx])
+			continue
+		}
+
+		val := eval(fn.Defaults[param.Value], env, r)
+		if isError(val) {
+			return nil, val
+		}
+		env.Set(param.Value, val)
 	}
 
-	return env
+	if fn.Rest != nil {
+		rest := []object.Object{}
+		if len(args) > len(fn.Parameters) {
+			rest = append(rest, args[len(fn.Parameters):]...)
+		}
+		restArray := r.allocate(&object.Array{Elements: rest})
+		if isError(restArray) {
+			return nil, restArray
+		}
+		env.Set(fn.Rest.Value, restArray)
+	}
+
+	return env, nil
+}
+
+func checkArity(fn *object.Function, got int) object.Object {
+	required := 0
+	for _, param := range fn.Parameters {
+		if _, ok := fn.Defaults[param.Value]; !ok {
+			required++
+		}
+	}
+
+	var want string
+	switch {
+	case fn.Rest != nil:
+		if got >= required {
+			return nil
+		}
+		want = fmt.Sprintf("at least %d", required)
+	case required == len(fn.Parameters):
+		if got == required {
+			return nil
+		}
+		want = fmt.Sprintf("%d", required)
+	default:
+		if got >= required && got <= len(fn.Parameters) {
+			return nil
+		}
+		want = fmt.Sprintf("%d to %d", required, len(fn.Parameters))
+	}
+
+	if fn.Name != "" {
+		return newError("wrong number of arguments to `%s`. got=%d, want=%s", fn.Name, got, want)
+	}
+	return newError("wrong number of arguments. got=%d, want=%s", got, want)
 }
 
 func unwrapReturnValue(obj object.Object) object.Object {
-	if returnValue, ok := obj.(*object.ReturnValue); ok {
-		return returnValue.Value
+	switch obj := obj.(type) {
+	case *object.ReturnValue:
+		return obj.Value
+	case *object.Break, *object.Continue:
+		// the parser rejects these outside loops, but ASTs can be built by hand
+		return newError("%s outside loop", obj.Inspect())
 	}
 
 	return obj
 }
 
+// evalStringInfixExpression concatenates strings with + and compares them
+// byte-wise, which for UTF-8 is the same as comparing code points.
 func evalStringInfixExpression(operator string, left, right object.Object) object.Object {
-	if operator != "+" {
-		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
-	}
-
 	leftVal := left.(*object.String).Value
 	rightVal := right.(*object.String).Value
-	return &object.String{Value: leftVal + rightVal}
+
+	switch operator {
+	case "+":
+		return &object.String{Value: leftVal + rightVal}
+	case "<":
+		return nativeBoolToBooleanObject(leftVal < rightVal)
+	case ">":
+		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "<=":
+		return nativeBoolToBooleanObject(leftVal <= rightVal)
+	case ">=":
+		return nativeBoolToBooleanObject(leftVal >= rightVal)
+	case "==":
+		return nativeBoolToBooleanObject(leftVal == rightVal)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal != rightVal)
+	default:
+		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+	}
 }
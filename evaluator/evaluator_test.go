@@ -1,10 +1,20 @@
 package evaluator
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"monkey/ast"
 	"monkey/lexer"
 	"monkey/object"
 	"monkey/parser"
+	"monkey/token"
+	"runtime/debug"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestEvalIntegerExpression(t *testing.T) {
@@ -35,6 +45,114 @@ func TestEvalIntegerExpression(t *testing.T) {
 	}
 }
 
+func TestEvalFloatExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"3.5", 3.5},
+		{"-2.5", -2.5},
+		{"1.5 + 1.5", 3},
+		{"1 + 0.5", 1.5},
+		{"0.5 * 4", 2},
+		{"7 / 2.0", 3.5},
+		{"10 - 0.25", 9.75},
+		{"1e3 / 8", 125},
+		{"-(1 - 3.5)", 2.5},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testFloatObject(t, evaluated, tt.expected)
+	}
+}
+
+func TestFloatComparison(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"1.5 < 2", true},
+		{"2 > 1.5", true},
+		{"1.0 == 1", true},
+		{"1 != 1.0", false},
+		{"0.1 + 0.2 == 0.3", false},
+		{"2.5 == 2.5", true},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testBooleanObject(t, evaluated, tt.expected)
+	}
+}
+
+func TestFloatInspect(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"2.0", "2.0"},
+		{"0.1 + 0.2", "0.30000000000000004"},
+		{"1e21", "1e+21"},
+		{"1 / 0.0", "+Inf"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("%s: wrong Inspect. expected=%q, got=%q",
+				tt.input, tt.expected, evaluated.Inspect())
+		}
+	}
+}
+
+func TestNumberConversionBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{"int(3.9)", 3},
+		{"int(-3.9)", -3},
+		{"int(7)", 7},
+		{`int("42")`, 42},
+		{`int("0x10")`, 16},
+		{`int("4.2")`, `could not convert "4.2" to INTEGER`},
+		{"int(1.0 / 0)", "`int`: +Inf out of INTEGER range"},
+		{"int(true)", "argument to `int` not supported, got BOOLEAN"},
+		{"float(2)", 2.0},
+		{`float("2.5")`, 2.5},
+		{`float("abc")`, `could not convert "abc" to FLOAT`},
+		{"round(2.5)", 3},
+		{"round(-2.5)", -3},
+		{"round(2.4)", 2},
+		{"floor(2.7)", 2},
+		{"floor(-2.2)", -3},
+		{"ceil(2.2)", 3},
+		{"ceil(5)", 5},
+		{`ceil("x")`, "argument to `ceil` must be INTEGER or FLOAT, got=STRING"},
+		{"floor(1, 2)", "wrong number of arguments. got=2, want=1"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case float64:
+			testFloatObject(t, evaluated, expected)
+		case string:
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("object is not Error. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if errObj.Message != expected {
+				t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+			}
+		}
+	}
+}
+
 func TestEvalBooleanExpression(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -59,6 +177,21 @@ func TestEvalBooleanExpression(t *testing.T) {
 		{"(1 < 2) == false", false},
 		{"(1 > 2) == true", false},
 		{"(1 > 2) == false", true},
+		{"1 <= 2", true},
+		{"2 <= 2", true},
+		{"3 <= 2", false},
+		{"1 >= 2", false},
+		{"2 >= 2", true},
+		{"3 >= 2", true},
+		{"1.5 <= 1.5", true},
+		{"2 >= 2.5", false},
+		{"1 + 1 <= 2 == true", true},
+		{`"a" < "b"`, true},
+		{`"abc" <= "abd"`, true},
+		{`"b" >= "a"`, true},
+		{`"b" > "b"`, false},
+		{`"ab" == "a" + "b"`, true},
+		{`"ab" != "ab"`, false},
 	}
 
 	for _, tt := range tests {
@@ -213,6 +346,22 @@ if (10 > 1) {
 			"foobar",
 			"identifier not found: foobar",
 		},
+		{
+			"true <= false",
+			"unknown operator: BOOLEAN <= BOOLEAN",
+		},
+		{
+			`"a" >= 1`,
+			"type mismatch: STRING >= INTEGER",
+		},
+		{
+			`"a" * "b"`,
+			"unknown operator: STRING * STRING",
+		},
+		{
+			"let = 5;",
+			"cannot evaluate statement with syntax errors",
+		},
 	}
 
 	for _, tt := range tests {
@@ -332,6 +481,21 @@ func testIntegerObject(t *testing.T, obj object.Object, expected int64) bool {
 	return true
 }
 
+func testFloatObject(t *testing.T, obj object.Object, expected float64) bool {
+	result, ok := obj.(*object.Float)
+	if !ok {
+		t.Errorf("object is not Float. got=%T (%+v)", obj, obj)
+		return false
+	}
+	if result.Value != expected {
+		t.Errorf("object has wrong value. got=%g, want=%g",
+			result.Value, expected)
+		return false
+	}
+
+	return true
+}
+
 func testBooleanObject(t *testing.T, obj object.Object, expected bool) bool {
 	result, ok := obj.(*object.Boolean)
 	if !ok {
@@ -391,6 +555,11 @@ func TestBuiltinFunction(t *testing.T) {
 		{`len("")`, 0},
 		{`len("four")`, 4},
 		{`len("Hello, World!")`, 13},
+		{`len("héllo")`, 5},
+		{`len("😀")`, 1},
+		{`bytelen("héllo")`, 6},
+		{`bytelen("😀")`, 4},
+		{`bytelen([1])`, "argument to `bytelen` must be STRING, got=ARRAY"},
 		{`len(1)`, "argument to `len` not suppported, got INTEGER"},
 		{`len("one", "two")`, "wrong number of arguments. got=2, want=1"},
 	}
@@ -500,3 +669,1406 @@ false: 6
 		testIntegerObject(t, pair.Value, expectedValue)
 	}
 }
+
+func TestErrorPositions(t *testing.T) {
+	tests := []struct {
+		input       string
+		expectedPos string
+	}{
+		{"5 + true;", "1:1"},
+		{"let x = 1;\nlet y = x + -true;", "2:13"},
+		{"let f = fn() {\n  foobar\n};\nf();", "2:3"},
+		{`len(1)`, "1:1"},
+		{`{fn(x) { x }: 1}`, "1:2"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned. got=%T(%+v)",
+				evaluated, evaluated)
+			continue
+		}
+
+		if errObj.Pos.String() != tt.expectedPos {
+			t.Errorf("%q: wrong error position. expected=%s, got=%s",
+				tt.input, tt.expectedPos, errObj.Pos)
+		}
+	}
+}
+
+func TestFloatHashKeys(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{`{1.5: "a"}[1.5]`, "a"},
+		{`{1: "one"}[1.0]`, "one"},
+		{`{2.0: "two"}[2]`, "two"},
+		{`{0.5: "a"}[0.25]`, nil},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		expected, ok := tt.expected.(string)
+		if !ok {
+			testNullObject(t, evaluated)
+			continue
+		}
+
+		str, ok := evaluated.(*object.String)
+		if !ok || str.Value != expected {
+			t.Errorf("%s: expected %q, got=%T (%+v)", tt.input, expected, evaluated, evaluated)
+		}
+	}
+}
+
+func TestLogicalOperators(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{"true && true", true},
+		{"true && false", false},
+		{"false && true", false},
+		{"false || true", true},
+		{"false || false", false},
+		{"true || false", true},
+		{"1 && 2", 2},
+		{"1 || 2", 1},
+		{"if (false) { 1 } || 3", 3},
+		{"if (false) { 1 } && 3", nil},
+		{"!true || !false", true},
+		{"!(true && false)", true},
+		{"!1 && 2", false},
+		{"!!(0 || false)", true},
+		{"1 < 2 && 2 < 3", true},
+		{"1 > 2 || 2 > 3", false},
+		{"false && foobar", false},
+		{"true || foobar", true},
+		{"false && len(1)", false},
+		{"1 || len(1)", 1},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		default:
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestLogicalOperatorErrors(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedMessage string
+	}{
+		{"true && foobar", "identifier not found: foobar"},
+		{"false || foobar", "identifier not found: foobar"},
+		{"foobar || true", "identifier not found: foobar"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+			continue
+		}
+		if errObj.Message != tt.expectedMessage {
+			t.Errorf("wrong error message. expected=%q, got=%q",
+				tt.expectedMessage, errObj.Message)
+		}
+	}
+}
+
+func TestArithmeticAndBitwiseOperators(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{"7 % 3", 7 % 3},
+		{"-7 % 3", -7 % 3},
+		{"7 % -3", 7 % -3},
+		{"2 ** 10", 1024},
+		{"2 ** 3 ** 2", 512},
+		{"-2 ** 2", -4},
+		{"(-2) ** 3", -8},
+		{"5 ** 0", 1},
+		{"2 ** -1", 0.5},
+		{"2.0 ** 0.5 * 2.0 ** 0.5", 2.0000000000000004},
+		{"7.5 % 2", 1.5},
+		{"6 & 3", 2},
+		{"6 | 3", 7},
+		{"6 ^ 3", 5},
+		{"~0", -1},
+		{"~5", -6},
+		{"1 << 4", 16},
+		{"-1 << 63", -1 << 63},
+		{"256 >> 4", 16},
+		{"-256 >> 4", -16},
+		{"-1 >> 100", -1},
+		{"5 >> 64", 0},
+		{"1 | 2 ^ 3 & 4 << 1", 1 | 2 ^ 3 & (4 << 1)},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case float64:
+			testFloatObject(t, evaluated, expected)
+		}
+	}
+}
+
+func TestBigIntegers(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected any
+	}{
+		{"9223372036854775807 + 1", "9223372036854775808"},
+		{"-9223372036854775807 - 2", "-9223372036854775809"},
+		{"4294967296 * 4294967296", "18446744073709551616"},
+		{"-9223372036854775807 - 1", math.MinInt64},
+		{"(-9223372036854775807 - 1) / -1", "9223372036854775808"},
+		{"-(-9223372036854775807 - 1)", "9223372036854775808"},
+		{"2 ** 64", "18446744073709551616"},
+		{"1 << 64", "18446744073709551616"},
+		{"2 ** 100 >> 99", 2},
+		{"2 ** 64 - 2 ** 64 + 1", 1},
+		{"(2 ** 64 + 1) % 2 ** 64", 1},
+		{"-(2 ** 64) / 2 ** 62", -4},
+		{"~(2 ** 64)", "-18446744073709551617"},
+		{"2 ** 64 & 2 ** 64 + 5", "18446744073709551616"},
+		{"-(2 ** 64) >> 1000", -1},
+		{"2 ** 64 >> 1000", 0},
+		{"99999999999999999999", "99999999999999999999"},
+		{"0x1_0000_0000_0000_0000", "18446744073709551616"},
+		{"-9223372036854775808", math.MinInt64},
+		{"2 ** 64 > 9223372036854775807", true},
+		{"-(2 ** 64) < 2 ** 64", true},
+		{"2 ** 64 == 1 << 64", true},
+		{"2 ** 64 != 2 ** 65", true},
+		{"2 ** 64 * 0.5", 9223372036854775808.0},
+		{"1 / 0.0 > 2 ** 2000", true},
+		{"-1 / 0.0 < -(2 ** 2000)", true},
+		{"2 ** 2000 < 1 / 0.0", true},
+		{"2 ** 2000 == 1 / 0.0", false},
+		{"2 ** 64 == 18446744073709551616.0", true},
+		{"2 ** 64 + 1 == 18446744073709551616.0", false},
+		{"2 ** 64 + 1 > 18446744073709551616.0", true},
+		{"9007199254740993 == 9007199254740992.0", false},
+		{"9007199254740993 > 9007199254740992.0", true},
+		{"0.0 / 0.0 == 2 ** 100", false},
+		{"0.0 / 0.0 != 2 ** 100", true},
+		{"2 ** 100 >= 0.0 / 0.0", false},
+		{"(2 ** 64) ** -1", 1.0 / 18446744073709551616},
+		{"int(1e30)", "1000000000000000019884624838656"},
+		{`int("18446744073709551616")`, "18446744073709551616"},
+		{"float(2 ** 64)", 18446744073709551616.0},
+		{"[1, 2][2 ** 64]", nil},
+		{`{2 ** 64: "big"}[1 << 64]`, "big"},
+		{`{2.0 ** 64: "big"}[2 ** 64]`, "big"},
+		{`{2 ** 64: "big"}[2 ** 65]`, nil},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case float64:
+			testFloatObject(t, evaluated, expected)
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case nil:
+			testNullObject(t, evaluated)
+		case string:
+			switch obj := evaluated.(type) {
+			case *object.BigInt:
+				if obj.Inspect() != expected {
+					t.Errorf("%s: wrong value. got=%s, want=%s", tt.input, obj.Inspect(), expected)
+				}
+			case *object.String:
+				if obj.Value != expected {
+					t.Errorf("%s: wrong value. got=%q, want=%q", tt.input, obj.Value, expected)
+				}
+			default:
+				t.Errorf("%s: object is not BigInt. got=%T (%+v)", tt.input, evaluated, evaluated)
+			}
+		}
+	}
+}
+
+func TestBigIntegerErrors(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedMessage string
+	}{
+		{"2 ** 64 / 0", "division by zero"},
+		{"2 ** 64 % 0", "division by zero"},
+		{"2 ** 64 << -1", "negative shift count: -1"},
+		{"3 ** 10000000", "integer result too large"},
+		{"1 << 2 ** 64", "integer result too large"},
+		{"2 ** 64 + true", "type mismatch: BIGINT + BOOLEAN"},
+		{"2 ** 64 & 1.5", "unknown operator: BIGINT & FLOAT"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("%s: no error object returned. got=%T(%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+		if errObj.Message != tt.expectedMessage {
+			t.Errorf("wrong error message. expected=%q, got=%q",
+				tt.expectedMessage, errObj.Message)
+		}
+	}
+}
+
+func TestArithmeticAndBitwiseOperatorErrors(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedMessage string
+	}{
+		{"1 << -1", "negative shift count: -1"},
+		{"1 >> -5", "negative shift count: -5"},
+		{"1.5 & 1", "unknown operator: FLOAT & INTEGER"},
+		{"~1.5", "unknown operator: ~FLOAT"},
+		{"~true", "unknown operator: ~BOOLEAN"},
+		{`"a" % "b"`, "unknown operator: STRING % STRING"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("%s: no error object returned. got=%T(%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+		if errObj.Message != tt.expectedMessage {
+			t.Errorf("wrong error message. expected=%q, got=%q",
+				tt.expectedMessage, errObj.Message)
+		}
+	}
+}
+
+func TestDivisionByZero(t *testing.T) {
+	tests := []struct {
+		input       string
+		expectedPos string
+	}{
+		{"1 / 0", "1:1"},
+		{"5 % 0", "1:1"},
+		{"let f = fn(x) {\n  10 / x\n};\nf(0);", "2:3"},
+		{"let zero = 0; [1, 2][1 % zero]", "1:22"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("%q: no error object returned. got=%T(%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+		if errObj.Message != "division by zero" {
+			t.Errorf("%q: wrong error message. got=%q", tt.input, errObj.Message)
+		}
+		if errObj.Pos.String() != tt.expectedPos {
+			t.Errorf("%q: wrong error position. expected=%s, got=%s",
+				tt.input, tt.expectedPos, errObj.Pos)
+		}
+	}
+}
+
+func TestPanicsBecomeInternalErrors(t *testing.T) {
+	builtins["boom"] = &object.Builtin{
+		Fn: func(args ...object.Object) object.Object {
+			panic("boom")
+		},
+	}
+	defer delete(builtins, "boom")
+
+	input := `let f = fn() {
+  1 + boom();
+};
+f() + 1;`
+
+	evaluated := testEval(input)
+
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+	}
+	if errObj.Message != "internal error: boom" {
+		t.Errorf("wrong error message. got=%q", errObj.Message)
+	}
+	if errObj.Pos.String() != "2:7" {
+		t.Errorf("wrong error position. expected=2:7, got=%s", errObj.Pos)
+	}
+}
+
+func TestPanicsOutsideCallsBecomeInternalErrors(t *testing.T) {
+	// a malformed tree that makes the evaluator dereference a nil operand
+	program := &ast.Program{
+		Statements: []ast.Statement{
+			&ast.ExpressionStatement{
+				Expression: &ast.PrefixExpression{Operator: "-"},
+			},
+		},
+	}
+
+	evaluated := Eval(program, object.NewEnvironment())
+
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+	}
+	if !strings.HasPrefix(errObj.Message, "internal error: ") {
+		t.Errorf("wrong error message. got=%q", errObj.Message)
+	}
+}
+
+func TestPanicsOutsideProgramsBecomeInternalErrors(t *testing.T) {
+	// malformed trees that make the evaluator dereference a nil operand
+	prefix := &ast.PrefixExpression{
+		Token:    token.Token{Type: token.MINUS, Literal: "-", Pos: token.Position{Line: 1, Column: 3}},
+		Operator: "-",
+	}
+
+	tests := []struct {
+		name string
+		node ast.Node
+	}{
+		{"expression", prefix},
+		{"block", &ast.BlockStatement{Statements: []ast.Statement{&ast.ExpressionStatement{Expression: prefix}}}},
+	}
+
+	for _, tt := range tests {
+		for _, evaluated := range []object.Object{
+			Eval(tt.node, object.NewEnvironment()),
+			EvalContext(context.Background(), tt.node, object.NewEnvironment(), Limits{}),
+		} {
+			errObj, ok := evaluated.(*object.Error)
+			if !ok {
+				t.Errorf("%s: no error object returned. got=%T(%+v)", tt.name, evaluated, evaluated)
+				continue
+			}
+			if !strings.HasPrefix(errObj.Message, "internal error: ") {
+				t.Errorf("%s: wrong error message. got=%q", tt.name, errObj.Message)
+			}
+		}
+	}
+
+	if errObj, ok := Eval(prefix, object.NewEnvironment()).(*object.Error); ok && errObj.Pos.String() != "1:3" {
+		t.Errorf("wrong error position. expected=1:3, got=%s", errObj.Pos)
+	}
+}
+
+func TestAssignExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let x = 1; x = 2; x;", 2},
+		{"let x = 1; x = 2;", 2},
+		{"let x = 1; let y = 1; x = y = 5; x + y;", 10},
+		{"let x = 10; x += 5; x;", 15},
+		{"let x = 10; x -= 5; x;", 5},
+		{"let x = 10; x *= 5; x;", 50},
+		{"let x = 10; x /= 4; x;", 2},
+		{"let x = 1.5; x *= 2; x;", 3.0},
+		{`let s = "foo"; s += "bar"; s;`, "foobar"},
+		{"let x = 9223372036854775807; x += 1; x > 0;", true},
+		{"let x = 1; let f = fn() { x = 2; }; f(); x;", 2},
+		{"let x = 1; let f = fn(x) { x = 2; }; f(5); x;", 1},
+		{"let x = 1; let f = fn() { let x = 5; x = 2; }; f(); x;", 1},
+		{`
+let counter = fn() {
+  let count = 0;
+  fn() { count += 1; }
+};
+let next = counter();
+next();
+next();
+next();`, 3},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case float64:
+			testFloatObject(t, evaluated, expected)
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		case string:
+			str, ok := evaluated.(*object.String)
+			if !ok {
+				t.Errorf("object is not String. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if str.Value != expected {
+				t.Errorf("String has wrong value. got=%q, want=%q", str.Value, expected)
+			}
+		}
+	}
+}
+
+func TestAssignExpressionErrors(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedMessage string
+		expectedPos     string
+	}{
+		{"x = 1;", "cannot assign to undeclared identifier: x", "1:1"},
+		{"let f = fn() { y = 1; }; f();", "cannot assign to undeclared identifier: y", "1:16"},
+		{"len = 1;", "cannot assign to undeclared identifier: len", "1:1"},
+		{"z += 1;", "identifier not found: z", "1:1"},
+		{"let x = 1; x += true;", "type mismatch: INTEGER + BOOLEAN", "1:12"},
+		{"let x = 1; x /= 0;", "division by zero", "1:12"},
+		{"let x = 1; x = missing;", "identifier not found: missing", "1:16"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("%s: no error object returned. got=%T(%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+		if errObj.Message != tt.expectedMessage {
+			t.Errorf("wrong error message. expected=%q, got=%q",
+				tt.expectedMessage, errObj.Message)
+		}
+		if errObj.Pos.String() != tt.expectedPos {
+			t.Errorf("%s: wrong error position. expected=%s, got=%s",
+				tt.input, tt.expectedPos, errObj.Pos)
+		}
+	}
+}
+
+func TestIndexAssignExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let a = [1, 2, 3]; a[0] = 5; a[0];", 5},
+		{"let a = [1, 2, 3]; a[2] = 5;", 5},
+		{"let a = [1, 2, 3]; a[1] += 10; a[1];", 12},
+		{"let a = [1, 2, 3]; let b = a; b[0] = 9; a[0];", 9},
+		{"let a = [[1], [2]]; a[1][0] *= 7; a[1][0];", 14},
+		{"let a = [1, 2]; let f = fn(arr) { arr[0] = 3; }; f(a); a[0];", 3},
+		{`let h = {}; h["k"] = 1; h["k"];`, 1},
+		{`let h = {"k": 1}; h["k"] -= 3; h["k"];`, -2},
+		{`let h = {}; h[1] = "one"; h[1.0];`, "one"},
+		{`let h = {}; h[true] = 1; h[false] = 2; h[true] + h[false];`, 3},
+		{`let h = {"a": [0, 0]}; h["a"][1] = 4; h["a"][1];`, 4},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			str, ok := evaluated.(*object.String)
+			if !ok {
+				t.Errorf("object is not String. got=%T (%+v)", evaluated, evaluated)
+				continue
+			}
+			if str.Value != expected {
+				t.Errorf("String has wrong value. got=%q, want=%q", str.Value, expected)
+			}
+		}
+	}
+}
+
+func TestIndexAssignExpressionErrors(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedMessage string
+		expectedPos     string
+	}{
+		{"let a = [1]; a[1] = 2;", "index out of range: 1 (array length 1)", "1:14"},
+		{"let a = [1]; a[-1] = 2;", "index out of range: -1 (array length 1)", "1:14"},
+		{"let a = [1]; a[2 ** 64] = 2;", "index out of range: 18446744073709551616 (array length 1)", "1:14"},
+		{`let a = [1]; a["0"] = 2;`, "array index must be INTEGER, got STRING", "1:16"},
+		{"let h = {}; h[fn(x) { x }] = 1;", "unusable as hash key: FUNCTION", "1:15"},
+		{`let h = {}; h["k"] += 1;`, `key not found: k`, "1:13"},
+		{`let s = "abc"; s[0] = "x";`, "index assignment not supported: STRING", "1:16"},
+		{"let a = [1]; a[0] += true;", "type mismatch: INTEGER + BOOLEAN", "1:14"},
+		{"b[0] = 1;", "identifier not found: b", "1:1"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("%s: no error object returned. got=%T(%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+		if errObj.Message != tt.expectedMessage {
+			t.Errorf("wrong error message. expected=%q, got=%q",
+				tt.expectedMessage, errObj.Message)
+		}
+		if errObj.Pos.String() != tt.expectedPos {
+			t.Errorf("%s: wrong error position. expected=%s, got=%s",
+				tt.input, tt.expectedPos, errObj.Pos)
+		}
+	}
+}
+
+func TestLoopControlInValuePosition(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let i = 0; while (i < 3) { let y = if (i == 1) { break; }; i += 1; } i;", 1},
+		{"let i = 0; let n = 0; while (i < 3) { i += 1; let y = if (i == 2) { continue; }; n += 1; } n;", 2},
+		{"let i = 0; let y = 0; while (i < 3) { y = if (i == 1) { break; }; i += 1; } i;", 1},
+		{"let i = 0; while (i < 3) { 1 + if (i == 1) { break; } else { 1 }; i += 1; } i;", 1},
+		{"let i = 0; for (x in [1, 2, 3]) { [if (x == 2) { break; } else { x }]; i = x; } i;", 1},
+		{`let i = 0; for (x in [1, 2, 3]) { len(if (x == 2) { break; } else { "a" }); i = x; } i;`, 1},
+		{"let f = fn() { let y = if (true) { return 7; }; 1 }; f();", 7},
+		{"let f = fn() { 1 + match (1) { 1 => if (true) { return 8; } } }; f();", 8},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestWhileStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let i = 0; while (i < 10) { i += 1; } i;", 10},
+		{"let i = 0; while (false) { i += 1; } i;", 0},
+		{"while (false) { 1 }", nil},
+		{"let i = 0; while (true) { i += 1; if (i == 5) { break; } } i;", 5},
+		{`
+let i = 0;
+let sum = 0;
+while (i < 10) {
+  i += 1;
+  if (i % 2 == 0) { continue; }
+  sum += i;
+}
+sum;`, 25},
+		{"let f = fn() { let i = 0; while (true) { i += 1; if (i > 3) { return i; } } }; f();", 4},
+		{"let i = 0; while (i < 100000) { i += 1; } i;", 100000},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		integer, ok := tt.expected.(int)
+		if ok {
+			testIntegerObject(t, evaluated, int64(integer))
+		} else {
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestForStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let sum = 0; for (x in [1, 2, 3]) { sum += x; } sum;", 6},
+		{"let sum = 0; for (x in []) { sum += x; } sum;", 0},
+		{`let sum = 0; for (k in {1: "a", 2: "b", 3: "c"}) { sum += k; } sum;`, 6},
+		{`let s = ""; for (c in "héllo") { s = c + s; } s;`, "olléh"},
+		{"let sum = 0; for (i in range(5)) { sum += i; } sum;", 10},
+		{"let sum = 0; for (i in range(2, 5)) { sum += i; } sum;", 9},
+		{"let sum = 0; for (i in range(10, 0, -3)) { sum += i; } sum;", 22},
+		{"let n = 0; for (i in range(5, 0)) { n += 1; } n;", 0},
+		{"let n = 0; for (i in range(9223372036854775806, 9223372036854775807, 5)) { n += 1; } n;", 1},
+		{"let n = 0; for (i in range(100)) { if (i == 7) { break; } n += 1; } n;", 7},
+		{"let n = 0; for (i in range(10)) { if (i % 3 != 0) { continue; } n += 1; } n;", 4},
+		{"let f = fn(xs) { for (x in xs) { if (x > 1) { return x; } } }; f([1, 5, 9]);", 5},
+		{"let a = [1, 2, 3]; for (x in a) { a[2] = 10; } a[2];", 10},
+		{"let x = 100; for (x in [1, 2]) { x; } x;", 100},
+		{"let n = 0; for (i in range(3)) { for (j in range(3)) { if (j == 1) { break; } n += 1; } } n;", 3},
+		{"for (x in [1]) { x }", nil},
+		{"range(1, 10, 2)", "range(1, 10, 2)"},
+		{"range(3)", "range(0, 3)"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			if evaluated.Inspect() != expected {
+				t.Errorf("%s: wrong value. got=%q, want=%q", tt.input, evaluated.Inspect(), expected)
+			}
+		default:
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestForStatementClosuresCaptureEachIteration(t *testing.T) {
+	input := `
+let fns = [];
+for (i in range(3)) {
+  fns = push(fns, fn() { i });
+}
+fns[0]() + fns[1]() * 10 + fns[2]() * 100;`
+
+	testIntegerObject(t, testEval(input), 210)
+}
+
+func TestLoopErrors(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedMessage string
+		expectedPos     string
+	}{
+		{"for (x in 5) { x }", "cannot iterate over INTEGER", "1:11"},
+		{"while (missing) { 1 }", "identifier not found: missing", "1:8"},
+		{"for (x in [1, 2]) { x + true }", "type mismatch: INTEGER + BOOLEAN", "1:21"},
+		{"range(1, 2, 0)", "`range`: step must not be zero", "1:1"},
+		{`range("a")`, "argument to `range` must be INTEGER, got=STRING", "1:1"},
+		{"range()", "wrong number of arguments. got=0, want=1 to 3", "1:1"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("%s: no error object returned. got=%T(%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+		if errObj.Message != tt.expectedMessage {
+			t.Errorf("wrong error message. expected=%q, got=%q",
+				tt.expectedMessage, errObj.Message)
+		}
+		if errObj.Pos.String() != tt.expectedPos {
+			t.Errorf("%s: wrong error position. expected=%s, got=%s",
+				tt.input, tt.expectedPos, errObj.Pos)
+		}
+	}
+}
+
+func TestLoopControlOutsideLoopInHandBuiltAST(t *testing.T) {
+	brk := &ast.BreakStatement{Token: token.Token{Type: token.BREAK, Literal: "break"}}
+	fn := &object.Function{
+		Body: &ast.BlockStatement{Statements: []ast.Statement{brk}},
+		Env:  object.NewEnvironment(),
+	}
+
+	tests := []struct {
+		name      string
+		evaluated object.Object
+	}{
+		{"program", Eval(&ast.Program{Statements: []ast.Statement{brk}}, object.NewEnvironment())},
+		{"function", applyFunction(fn, nil, nil)},
+	}
+
+	for _, tt := range tests {
+		errObj, ok := tt.evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("%s: no error object returned. got=%T(%+v)", tt.name, tt.evaluated, tt.evaluated)
+			continue
+		}
+		if errObj.Message != "break outside loop" {
+			t.Errorf("%s: wrong error message. got=%q", tt.name, errObj.Message)
+		}
+	}
+}
+
+func TestElseIfExpressions(t *testing.T) {
+	input := `
+let sign = fn(x) {
+  if (x < 0) { "negative" } else if (x == 0) { "zero" } else { "positive" }
+};
+[sign(-5), sign(0), sign(5)];`
+
+	evaluated := testEval(input)
+	if evaluated.Inspect() != "[negative, zero, positive]" {
+		t.Errorf("wrong result. got=%s", evaluated.Inspect())
+	}
+
+	testNullObject(t, testEval("if (false) { 1 } else if (false) { 2 }"))
+}
+
+func TestMatchExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`match (1) { 1 => "one", _ => "other" }`, "one"},
+		{`match (2) { 1 => "one", _ => "other" }`, "other"},
+		{`match (1.0) { 1 => "one", _ => "other" }`, "one"},
+		{`match (-3) { -3 => "neg", _ => "other" }`, "neg"},
+		{`match ("b") { "a" => 1, "b" => 2 }`, 2},
+		{`match (true) { false => 1, true => 2 }`, 2},
+		{`match (1) { "1" => "string", 1 => "int" }`, "int"},
+		{`match (5) { n => n * 2 }`, 10},
+		{`match ([1, 2]) { [a] => a, [a, b] => a + b }`, 3},
+		{`match ([1, [2, 3]]) { [a, [b, c]] => a + b + c }`, 6},
+		{`match ([1, 2]) { [_, 3] => 0, [_, 2] => 1 }`, 1},
+		{`match ({"x": 1, "y": 2}) { {"x": x, "y": y} => x + y }`, 3},
+		{`match ({"x": 1}) { {"y": y} => y, {"x": x} => x }`, 1},
+		{`match ({"kind": "circle", "r": 2}) { {"kind": "square", "side": s} => s * s, {"kind": "circle", "r": r} => 3 * r * r }`, 12},
+		{`match ([]) { {} => "hash", [] => "array" }`, "array"},
+		{`let x = 1; match (2) { x => x }; x;`, 1},
+		{`let f = fn(n) { match (n) { 0 => 1, _ => n * f(n - 1) } }; f(5);`, 120},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			str, ok := evaluated.(*object.String)
+			if !ok {
+				t.Errorf("%s: object is not String. got=%T (%+v)", tt.input, evaluated, evaluated)
+				continue
+			}
+			if str.Value != expected {
+				t.Errorf("String has wrong value. got=%q, want=%q", str.Value, expected)
+			}
+		}
+	}
+}
+
+func TestMatchExpressionErrors(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedMessage string
+		expectedPos     string
+	}{
+		{"match (3) { 1 => 1, 2 => 2 }", "no match for 3", "1:1"},
+		{"let x = match ([1]) { [] => 0 }; x;", "no match for [1]", "1:9"},
+		{"match (missing) { _ => 1 }", "identifier not found: missing", "1:8"},
+		{"match (1) { n => n + true }", "type mismatch: INTEGER + BOOLEAN", "1:18"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("%s: no error object returned. got=%T(%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+		if errObj.Message != tt.expectedMessage {
+			t.Errorf("wrong error message. expected=%q, got=%q",
+				tt.expectedMessage, errObj.Message)
+		}
+		if errObj.Pos.String() != tt.expectedPos {
+			t.Errorf("%s: wrong error position. expected=%s, got=%s",
+				tt.input, tt.expectedPos, errObj.Pos)
+		}
+	}
+}
+
+func TestDestructuringLetStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let [a, b] = [1, 2]; a + b;", 3},
+		{"let [a, [b, c]] = [1, [2, 3]]; a + b + c;", 6},
+		{"let [_, b] = [1, 2]; b;", 2},
+		{"let [first, ...rest] = [1, 2, 3]; rest;", "[2, 3]"},
+		{"let [first, ...rest] = [1]; rest;", "[]"},
+		{"let [...all] = [1, 2]; all;", "[1, 2]"},
+		{"let pair = fn() { [10, 20] }; let [x, y] = pair(); y - x;", 10},
+		{`let {name, age: years} = {"name": "Ann", "age": 30}; name;`, "Ann"},
+		{`let {name, age: years} = {"name": "Ann", "age": 30}; years;`, 30},
+		{`let {1: one, true: yes} = {1: "a", true: "b"}; one + yes;`, "ab"},
+		{`let {point: [x, y]} = {"point": [3, 4], "extra": 0}; x * y;`, 12},
+		{"let [a, b] = [1, 2]; let [a, b] = [b, a]; a - b;", 1},
+		{"let a = [1, 2, 3]; let [x, ...rest] = a; rest[0] = 9; a[1];", 2},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			if evaluated == nil || evaluated.Inspect() != expected {
+				t.Errorf("%s: wrong value. got=%v, want=%q", tt.input, evaluated, expected)
+			}
+		}
+	}
+}
+
+func TestDestructuringLetErrors(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedMessage string
+		expectedPos     string
+	}{
+		{"let [a, b] = 5;", "cannot destructure: expected ARRAY, got INTEGER", "1:5"},
+		{"let [a, b] = [1, 2, 3];", "cannot destructure: expected 2 elements, got 3", "1:5"},
+		{"let [a, b, ...c] = [1];", "cannot destructure: expected at least 2 elements, got 1", "1:5"},
+		{"let [a, [b]] = [1, 2];", "cannot destructure: expected ARRAY, got INTEGER", "1:9"},
+		{`let {name} = [1];`, "cannot destructure: expected HASH, got ARRAY", "1:5"},
+		{`let {name, age} = {"name": 1};`, "cannot destructure: key not found: age", "1:5"},
+		{"let [1, a] = [2, 3];", "cannot destructure: expected 1, got 2", "1:6"},
+		{"let [a] = [missing];", "identifier not found: missing", "1:12"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("%s: no error object returned. got=%T(%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+		if errObj.Message != tt.expectedMessage {
+			t.Errorf("wrong error message. expected=%q, got=%q",
+				tt.expectedMessage, errObj.Message)
+		}
+		if errObj.Pos.String() != tt.expectedPos {
+			t.Errorf("%s: wrong error position. expected=%s, got=%s",
+				tt.input, tt.expectedPos, errObj.Pos)
+		}
+	}
+}
+
+func TestMatchRestPatterns(t *testing.T) {
+	input := `
+let sum = fn(xs) {
+  match (xs) { [] => 0, [x, ...rest] => x + sum(rest) }
+};
+sum([1, 2, 3, 4]);`
+
+	testIntegerObject(t, testEval(input), 10)
+}
+
+func TestDefaultAndRestParameters(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let f = fn(x, y = 10) { x + y }; f(1);", 11},
+		{"let f = fn(x, y = 10) { x + y }; f(1, 2);", 3},
+		{"let f = fn(x = 1, y = x * 2) { x + y }; f();", 3},
+		{"let f = fn(x = 1, y = x * 2) { x + y }; f(5);", 15},
+		{"let n = 100; let f = fn(x = n) { x }; let n = 7; f();", 7},
+		{"let f = fn(head, ...tail) { tail }; f(1, 2, 3);", "[2, 3]"},
+		{"let f = fn(head, ...tail) { tail }; f(1);", "[]"},
+		{"let f = fn(...args) { len(args) }; f(1, 2, 3, 4);", 4},
+		{"let f = fn(a, b = 2, ...c) { [a, b, c] }; f(1);", "[1, 2, []]"},
+		{"let f = fn(a, b = 2, ...c) { [a, b, c] }; f(1, 3, 5, 7);", "[1, 3, [5, 7]]"},
+		{"fn(x, y = 1) { x }", "fn(x, y = 1) {\nx\n}"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			if evaluated == nil || evaluated.Inspect() != expected {
+				t.Errorf("%s: wrong value. got=%v, want=%q", tt.input, evaluated.Inspect(), expected)
+			}
+		}
+	}
+}
+
+func TestArityErrors(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedMessage string
+		expectedPos     string
+	}{
+		{"let add = fn(x, y) { x + y }; add(1);", "wrong number of arguments to `add`. got=1, want=2", "1:31"},
+		{"let add = fn(x, y) { x + y }; add(1, 2, 3);", "wrong number of arguments to `add`. got=3, want=2", "1:31"},
+		{"fn(x) { x }();", "wrong number of arguments. got=0, want=1", "1:1"},
+		{"let f = fn(x, y = 1) { x }; f();", "wrong number of arguments to `f`. got=0, want=1 to 2", "1:29"},
+		{"let f = fn(x, y = 1) { x }; f(1, 2, 3);", "wrong number of arguments to `f`. got=3, want=1 to 2", "1:29"},
+		{"let f = fn(x, ...r) { x }; f();", "wrong number of arguments to `f`. got=0, want=at least 1", "1:28"},
+		{"let f = fn(x = missing) { x }; f();", "identifier not found: missing", "1:16"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("%s: no error object returned. got=%T(%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+		if errObj.Message != tt.expectedMessage {
+			t.Errorf("wrong error message. expected=%q, got=%q",
+				tt.expectedMessage, errObj.Message)
+		}
+		if errObj.Pos.String() != tt.expectedPos {
+			t.Errorf("%s: wrong error position. expected=%s, got=%s",
+				tt.input, tt.expectedPos, errObj.Pos)
+		}
+	}
+}
+
+func TestFunctionStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"fn add(x, y) { x + y } add(2, 3);", 5},
+		{"fn fact(n) { if (n == 0) { 1 } else { n * fact(n - 1) } } fact(5);", 120},
+		{`
+fn isEven(n) { if (n == 0) { true } else { isOdd(n - 1) } }
+fn isOdd(n) { if (n == 0) { false } else { isEven(n - 1) } }
+if (isEven(10)) { 1 } else { 0 };`, 1},
+		{"let f = fn() { fn inner() { 7 } inner() }; f();", 7},
+		{"fn counter() { let n = 0; fn() { n += 1 } } let c = counter(); c(); c();", 2},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestFunctionStatementIsScoped(t *testing.T) {
+	evaluated := testEval("let f = fn() { fn inner() { 7 } 0 }; f(); inner;")
+
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+	}
+	if errObj.Message != "identifier not found: inner" {
+		t.Errorf("wrong error message. got=%q", errObj.Message)
+	}
+}
+
+func TestFunctionNames(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"fn add(x, y) { x + y } add;", "fn add(x, y) {\n(x + y)\n}"},
+		{"let double = fn(x) { x * 2 }; double;", "fn double(x) {\n(x * 2)\n}"},
+		{"fn(x) { x };", "fn(x) {\nx\n}"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated == nil || evaluated.Inspect() != tt.expected {
+			t.Errorf("%s: wrong Inspect. got=%q, want=%q", tt.input, evaluated.Inspect(), tt.expected)
+		}
+	}
+
+	evaluated := testEval("fn add(x, y) { x + y } add(1);")
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+	}
+	if errObj.Message != "wrong number of arguments to `add`. got=1, want=2" {
+		t.Errorf("wrong error message. got=%q", errObj.Message)
+	}
+}
+
+func TestTailCalls(t *testing.T) {
+	// Each level of non-tail recursion takes over 1KB of Go stack, so 100000
+	// levels cannot fit in 64MB: without tail calls running in constant
+	// stack these inputs die of a Go stack overflow.
+	defer debug.SetMaxStack(debug.SetMaxStack(64 << 20))
+
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"fn count(n, acc) { if (n == 0) { acc } else { count(n - 1, acc + 1) } } count(100000, 0);", 100000},
+		{"fn count(n, acc) { if (n == 0) { return acc; } return count(n - 1, acc + 1); } count(100000, 0);", 100000},
+		{"fn count(n) { match (n) { 0 => 0, _ => count(n - 1) } } count(100000);", 0},
+		{`
+fn isEven(n) { if (n == 0) { true } else { isOdd(n - 1) } }
+fn isOdd(n) { if (n == 0) { false } else { isEven(n - 1) } }
+if (isEven(100001)) { 1 } else { 0 };`, 0},
+		{"fn f(n) { while (true) { return len([n]); } } f(7);", 1},
+		{"fn fact(n) { if (n == 0) { 1 } else { n * fact(n - 1) } } fact(20);", 2432902008176640000},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestTailCallErrors(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedMessage string
+		expectedPos     string
+	}{
+		{"fn f(x) { g(x) } fn g(x, y) { x } f(1);", "wrong number of arguments to `g`. got=1, want=2", "1:11"},
+		{"fn f(x) { len(x) } f(1);", "argument to `len` not suppported, got INTEGER", "1:11"},
+		{"fn f(x) { x() } f(1);", "not a function: INTEGER", "1:11"},
+		{"fn f(n) { if (n == 0) { missing } else { f(n - 1) } } f(1000);", "identifier not found: missing", "1:25"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("%s: no error object returned. got=%T(%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+		if errObj.Message != tt.expectedMessage {
+			t.Errorf("wrong error message. expected=%q, got=%q",
+				tt.expectedMessage, errObj.Message)
+		}
+		if errObj.Pos.String() != tt.expectedPos {
+			t.Errorf("%s: wrong error position. expected=%s, got=%s",
+				tt.input, tt.expectedPos, errObj.Pos)
+		}
+	}
+}
+
+func TestMaxCallDepth(t *testing.T) {
+	limits := Limits{MaxCallDepth: 100}
+	eval := func(input string) object.Object {
+		return testEvalContext(context.Background(), input, limits)
+	}
+
+	input := "fn f(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }"
+
+	testIntegerObject(t, eval(input+" f(99);"), 99)
+
+	evaluated := eval(input + " f(100);")
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+	}
+	expected := "maximum recursion depth exceeded (limit 100)"
+	if errObj.Message != expected {
+		t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+	}
+	if errObj.Pos.String() != "1:40" {
+		t.Errorf("wrong error position. expected=1:40, got=%s", errObj.Pos)
+	}
+
+	// the depth unwinds after an error, so the next run starts from zero
+	testIntegerObject(t, eval(input+" f(99);"), 99)
+
+	// tail calls do not nest
+	testIntegerObject(t, eval("fn g(n) { if (n == 0) { 0 } else { g(n - 1) } } g(1000);"), 0)
+
+	limits.MaxCallDepth = 0
+	testIntegerObject(t, eval(input+" f(20000);"), 20000)
+}
+
+func TestCallDepthIsPerEvaluation(t *testing.T) {
+	input := fmt.Sprintf("fn f(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } } f(%d);", DefaultMaxCallDepth-1)
+
+	results := make([]object.Object, 4)
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = testEval(input)
+		}(i)
+	}
+	wg.Wait()
+
+	for _, result := range results {
+		testIntegerObject(t, result, int64(DefaultMaxCallDepth-1))
+	}
+}
+
+func TestDefaultMaxCallDepth(t *testing.T) {
+	input := "fn f(n) { f(n + 1) + 1 } f(0);"
+
+	evaluated := testEval(input)
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+	}
+	expected := fmt.Sprintf("maximum recursion depth exceeded (limit %d)", DefaultMaxCallDepth)
+	if errObj.Message != expected {
+		t.Errorf("wrong error message. expected=%q, got=%q", expected, errObj.Message)
+	}
+}
+
+func testEvalContext(ctx context.Context, input string, limits Limits) object.Object {
+	l := lexer.New(input)
+	p := parser.New(l)
+	program := p.ParseProgram()
+	env := object.NewEnvironment()
+
+	return EvalContext(ctx, program, env, limits)
+}
+
+func TestEvalContextStops(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	background := func() (context.Context, context.CancelFunc) {
+		return context.WithCancel(context.Background())
+	}
+
+	tests := []struct {
+		name            string
+		ctx             func() (context.Context, context.CancelFunc)
+		input           string
+		limits          Limits
+		expectedMessage string
+		expectedCause   error
+		expectedPos     string
+	}{
+		{
+			"cancelled",
+			func() (context.Context, context.CancelFunc) { return cancelled, func() {} },
+			"1 + 1",
+			Limits{},
+			"evaluation cancelled: context canceled",
+			context.Canceled,
+			"-",
+		},
+		{
+			"deadline in loop",
+			func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 10*time.Millisecond)
+			},
+			"while (true) { }",
+			Limits{},
+			"evaluation cancelled: context deadline exceeded",
+			context.DeadlineExceeded,
+			"1:14",
+		},
+		{
+			"deadline in tail recursion",
+			func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 10*time.Millisecond)
+			},
+			"fn f() { f() } f();",
+			Limits{},
+			"evaluation cancelled: context deadline exceeded",
+			context.DeadlineExceeded,
+			"1:16",
+		},
+		{
+			"steps in loop",
+			background,
+			"let i = 0; while (true) { i += 1 }",
+			Limits{MaxSteps: 100},
+			"step limit exceeded (limit 100)",
+			ErrStepLimit,
+			"1:25",
+		},
+		{
+			"steps in calls",
+			background,
+			"fn f(n) { if (n == 0) { 0 } else { f(n - 1) } } f(1000);",
+			Limits{MaxSteps: 100},
+			"step limit exceeded (limit 100)",
+			ErrStepLimit,
+			"1:49",
+		},
+	}
+
+	for _, tt := range tests {
+		ctx, cancel := tt.ctx()
+		evaluated := testEvalContext(ctx, tt.input, tt.limits)
+		cancel()
+
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("%s: no error object returned. got=%T(%+v)", tt.name, evaluated, evaluated)
+			continue
+		}
+		if errObj.Message != tt.expectedMessage {
+			t.Errorf("%s: wrong error message. expected=%q, got=%q",
+				tt.name, tt.expectedMessage, errObj.Message)
+		}
+		if !errors.Is(errObj.Cause, tt.expectedCause) {
+			t.Errorf("%s: wrong error cause. expected=%v, got=%v",
+				tt.name, tt.expectedCause, errObj.Cause)
+		}
+		if errObj.Pos.String() != tt.expectedPos {
+			t.Errorf("%s: wrong error position. expected=%s, got=%s",
+				tt.name, tt.expectedPos, errObj.Pos)
+		}
+	}
+}
+
+func TestEvalContextWithinLimits(t *testing.T) {
+	input := "let sum = 0; for (i in range(10)) { sum += i } fn f(n) { n } f(sum);"
+
+	// 1 to start, 10 iterations and 2 calls
+	evaluated := testEvalContext(context.Background(), input, Limits{MaxSteps: 13})
+	testIntegerObject(t, evaluated, 45)
+
+	evaluated = testEvalContext(context.Background(), input, Limits{MaxSteps: 12})
+	if errObj, ok := evaluated.(*object.Error); !ok || errObj.Cause != ErrStepLimit {
+		t.Errorf("expected step limit error. got=%T(%+v)", evaluated, evaluated)
+	}
+
+	// errors of the program itself have no cause
+	evaluated = testEvalContext(context.Background(), "1 + true", Limits{MaxSteps: 13})
+	if errObj, ok := evaluated.(*object.Error); !ok || errObj.Cause != nil {
+		t.Errorf("expected error without cause. got=%T(%+v)", evaluated, evaluated)
+	}
+}
+
+func TestEvalContextRunsClosuresInCallerRun(t *testing.T) {
+	env := object.NewEnvironment()
+	define := parser.New(lexer.New("let loop = fn() { while (true) { } };")).ParseProgram()
+	call := parser.New(lexer.New("loop();")).ParseProgram()
+
+	Eval(define, env)
+
+	evaluated := EvalContext(context.Background(), call, env, Limits{MaxSteps: 50})
+	if errObj, ok := evaluated.(*object.Error); !ok || errObj.Cause != ErrStepLimit {
+		t.Errorf("expected step limit error. got=%T(%+v)", evaluated, evaluated)
+	}
+
+	// closures made in a limited run are not bound by it once it is over
+	define = parser.New(lexer.New(`
+let count = fn(n) {
+  let i = 0;
+  let step = fn() { i += 1 };
+  while (i < n) { step() }
+  fn() { while (i < 2 * n) { step() } i }
+}(2);`)).ParseProgram()
+	call = parser.New(lexer.New("count();")).ParseProgram()
+
+	evaluated = EvalContext(context.Background(), define, env, Limits{MaxSteps: 10})
+	if isError(evaluated) {
+		t.Fatalf("unexpected error. got=%+v", evaluated)
+	}
+	testIntegerObject(t, Eval(call, env), 4)
+}
+
+func TestMemoryLimit(t *testing.T) {
+	tests := []struct {
+		input       string
+		expectedPos string
+	}{
+		{`let s = "xxxxxxxxxxxxxxxx"; while (true) { s = s + s }`, "1:48"},
+		{`let s = "x"; while (true) { s += s }`, "1:29"},
+		{"let a = []; while (true) { a = push(a, 1) }", "1:32"},
+		{"let a = rest(rest([1, 2, 3, 4, 5, 6, 7, 8, 9, 10])); while (true) { rest(a) }", "1:69"},
+		{"let h = {}; let i = 0; while (true) { h[i] = i; i += 1 }", "1:39"},
+		{"while (true) { [1, 2, 3] }", "1:16"},
+		{"while (true) { {1: 2} }", "1:16"},
+		{`for (c in "abcdefghijklmnopqrstuvwxyz") { } while (true) { "abc" }`, "1:60"},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEvalContext(context.Background(), tt.input, Limits{MaxBytes: 4096})
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("%s: no error object returned. got=%T(%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+		if errObj.Message != "memory limit exceeded (limit 4096 bytes)" {
+			t.Errorf("%s: wrong error message. got=%q", tt.input, errObj.Message)
+		}
+		if errObj.Cause != ErrMemoryLimit {
+			t.Errorf("%s: wrong error cause. got=%v", tt.input, errObj.Cause)
+		}
+		if errObj.Pos.String() != tt.expectedPos {
+			t.Errorf("%s: wrong error position. expected=%s, got=%s",
+				tt.input, tt.expectedPos, errObj.Pos)
+		}
+	}
+}
+
+func TestMemoryLimitDoesNotChargeSharedValues(t *testing.T) {
+	input := `
+let big = push([], "` + strings.Repeat("x", 1000) + `");
+let i = 0;
+while (i < 100) { first(big); last(big); len(big); i += 1 }
+i;`
+
+	evaluated := testEvalContext(context.Background(), input, Limits{MaxBytes: 4096})
+	testIntegerObject(t, evaluated, 100)
+}
+
+func TestErrorStackTraces(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedStack []object.Frame
+	}{
+		{"1 + true", nil},
+		{"fn f(x) { x } f(1, 2);", nil},
+		{"fn f() { len(1) } f();", []object.Frame{{Function: "f", Pos: token.Position{Line: 1, Column: 19}}}},
+		{
+			"let add = fn(a, b) { a + b }; fn g(x) { let y = add(x, true); y } g(1);",
+			[]object.Frame{
+				{Function: "add", Pos: token.Position{Line: 1, Column: 49}},
+				{Function: "g", Pos: token.Position{Line: 1, Column: 67}},
+			},
+		},
+		{
+			"fn(x) { x + true }(1);",
+			[]object.Frame{{Function: "", Pos: token.Position{Line: 1, Column: 1}}},
+		},
+		{
+			// the tail call to g replaces the frame of f
+			"fn g() { 1 + true } fn f() { g() } fn h() { f(); } h();",
+			[]object.Frame{
+				{Function: "g", Pos: token.Position{Line: 1, Column: 30}},
+				{Function: "h", Pos: token.Position{Line: 1, Column: 52}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("%s: no error object returned. got=%T(%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+		if len(errObj.Stack) != len(tt.expectedStack) {
+			t.Errorf("%s: wrong stack. expected=%v, got=%v", tt.input, tt.expectedStack, errObj.Stack)
+			continue
+		}
+		for i, frame := range tt.expectedStack {
+			got := errObj.Stack[i]
+			if got.Function != frame.Function || got.Pos.String() != frame.Pos.String() {
+				t.Errorf("%s: wrong frame %d. expected=%v, got=%v", tt.input, i, frame, got)
+			}
+		}
+	}
+}
+
+func TestErrorTraceback(t *testing.T) {
+	input := `fn f(n) {
+  if (n == 0) { fn(x) { x + true }(n) } else { 1 + f(n - 1) }
+}
+f(3);`
+
+	evaluated := testEval(input)
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+	}
+
+	expected := `Traceback (most recent call last):
+  4:1: in call to f
+  2:52: in call to f
+  [previous frame repeated 2 more times]
+  2:17: in call to anonymous function
+ERROR: 2:25: type mismatch: INTEGER + BOOLEAN`
+	if errObj.Traceback() != expected {
+		t.Errorf("wrong traceback. expected=\n%s\ngot=\n%s", expected, errObj.Traceback())
+	}
+}
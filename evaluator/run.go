@@ -0,0 +1,136 @@
+package evaluator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"monkey/ast"
+	"monkey/object"
+)
+
+// ErrStepLimit is the Cause of the error ending an evaluation that used up
+// its step budget. Evaluations stopped by their context have the context
+// error, context.Canceled or context.DeadlineExceeded, as Cause.
+var ErrStepLimit = errors.New("step limit exceeded")
+
+// ErrMemoryLimit is the Cause of the error ending an evaluation that
+// allocated more than its memory quota.
+var ErrMemoryLimit = errors.New("memory limit exceeded")
+
+// Limits bound the work of a single evaluation. A zero field means no limit.
+type Limits struct {
+	// MaxSteps is the number of steps allowed, a step being a function call
+	// or a loop iteration.
+	MaxSteps int
+	// MaxCallDepth is the number of nested function calls allowed before
+	// evaluation stops with a "maximum recursion depth exceeded" error.
+	// Calls in tail position do not nest.
+	MaxCallDepth int
+	// MaxBytes is the number of bytes the evaluation may allocate for
+	// strings, arrays and hashes, as estimated by sizeOf. Memory is not
+	// given back when values become garbage, so this bounds the total
+	// allocated over the whole evaluation.
+	MaxBytes int
+}
+
+// run is the state of a single evaluation. The evaluator passes it along
+// with the environment, so that evaluations running at the same time do not
+// see each other's state.
+type run struct {
+	ctx    context.Context
+	limits Limits
+	steps  int
+	depth  int // the number of calls currently running
+	bytes  int // the bytes allocated so far
+}
+
+// EvalContext evaluates node like Eval, stopping with an error whose Cause
+// is set as soon as ctx is done or the evaluation goes over limits. Both are
+// checked at every function call and loop iteration.
+//
+// EvalContext is the recover boundary of every evaluation: a Go panic
+// escaping from the evaluator becomes an internal error rather than taking
+// the host down.
+//
+// Environments are not safe for concurrent use: evaluations running at the
+// same time must not share env, or any environment captured by a closure.
+func EvalContext(
+	ctx context.Context,
+	node ast.Node,
+	env *object.Environment,
+	limits Limits,
+) (result object.Object) {
+	r := &run{ctx: ctx, limits: limits}
+
+	defer func() {
+		if r := recover(); r != nil {
+			result = errorAt(node, newInternalError(r))
+		}
+	}()
+
+	if err := r.step(); err != nil {
+		return err
+	}
+	return eval(node, env, r)
+}
+
+// step counts one step, returning an error when the evaluation must stop.
+func (r *run) step() *object.Error {
+	if err := r.ctx.Err(); err != nil {
+		return &object.Error{Message: "evaluation cancelled: " + err.Error(), Cause: err}
+	}
+
+	r.steps++
+	if r.limits.MaxSteps > 0 && r.steps > r.limits.MaxSteps {
+		return &object.Error{
+			Message: fmt.Sprintf("%s (limit %d)", ErrStepLimit, r.limits.MaxSteps),
+			Cause:   ErrStepLimit,
+		}
+	}
+	return nil
+}
+
+// allocate charges the estimated size of obj, if it is a string, an array
+// or a hash, against the memory quota of r. It returns an error instead of
+// obj when the quota is exceeded.
+func (r *run) allocate(obj object.Object) object.Object {
+	if err := r.charge(sizeOf(obj)); err != nil {
+		return err
+	}
+	return obj
+}
+
+// charge counts bytes against the memory quota of r, returning an error
+// when the evaluation must stop.
+func (r *run) charge(bytes int) *object.Error {
+	r.bytes += bytes
+	if r.limits.MaxBytes > 0 && r.bytes > r.limits.MaxBytes {
+		return &object.Error{
+			Message: fmt.Sprintf("%s (limit %d bytes)", ErrMemoryLimit, r.limits.MaxBytes),
+			Cause:   ErrMemoryLimit,
+		}
+	}
+	return nil
+}
+
+// Rough sizes in bytes of the parts of values, for the memory quota.
+const (
+	headerSize  = 32 // an object with its header
+	elementSize = 16 // an element of an array, an interface value
+	pairSize    = 64 // an entry of a hash: its key, its pair and their overhead
+)
+
+// sizeOf estimates the bytes allocated to build obj. The elements of arrays
+// and hashes are not counted, they were charged when they were built.
+func sizeOf(obj object.Object) int {
+	switch obj := obj.(type) {
+	case *object.String:
+		return headerSize + len(obj.Value)
+	case *object.Array:
+		return headerSize + elementSize*len(obj.Elements)
+	case *object.Hash:
+		return headerSize + pairSize*len(obj.Pairs)
+	default:
+		return 0
+	}
+}
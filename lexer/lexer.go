@@ -1,25 +1,65 @@
 package lexer
 
-import "monkey/token"
+import (
+	"fmt"
+	"monkey/token"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
 
+// Error is a lexical error, reported alongside the ILLEGAL token covering
+// the offending source.
+type Error struct {
+	Pos token.Position
+	End token.Position
+	Msg string
+}
+
+// Lexer turns UTF-8 encoded source into tokens. Offsets are byte offsets
+// into the input, and every position carries both a byte and a rune column.
 type Lexer struct {
 	input        string
+	filename     string
 	position     int  // current position in input (points to current char)
 	readPosition int  // current reading position in input (after current char)
-	ch           byte // current char under examination
+	ch           rune // current char under examination
+	line         int  // line of the current char
+	lineStart    int  // offset of the first char of the current line
+	runeColumn   int  // rune column of the current char
+
+	emitComments bool
+	errors       []Error
 }
 
 func New(input string) *Lexer {
-	l := &Lexer{input: input}
+	return NewWithFilename("", input)
+}
+
+// NewWithFilename returns a Lexer whose token positions report filename.
+func NewWithFilename(filename, input string) *Lexer {
+	l := &Lexer{input: input, filename: filename, line: 1}
 	l.readChar()
 	return l
 }
 
+// EmitComments makes NextToken return comments as token.COMMENT instead of
+// skipping them.
+func (l *Lexer) EmitComments(emit bool) {
+	l.emitComments = emit
+}
+
+func (l *Lexer) Errors() []Error {
+	return l.errors
+}
+
 func (l *Lexer) NextToken() token.Token {
 	var tok token.Token
 
 	l.skipWhitespace()
 
+	pos := l.currentPosition()
+
 	switch l.ch {
 	case '[':
 		tok = newToken(token.LBRACKET, l.ch)
@@ -31,15 +71,56 @@ func (l *Lexer) NextToken() token.Token {
 			l.readChar()
 			literal := string(ch) + string(l.ch)
 			tok = token.Token{Type: token.EQ, Literal: literal}
+		} else if l.peekChar() == '>' {
+			l.readChar()
+			tok = token.Token{Type: token.ARROW, Literal: "=>"}
 		} else {
 			tok = newToken(token.ASSIGN, l.ch)
 		}
 	case '+':
-		tok = newToken(token.PLUS, l.ch)
+		if l.peekChar() == '=' {
+			l.readChar()
+			tok = token.Token{Type: token.PLUS_ASSIGN, Literal: "+="}
+		} else {
+			tok = newToken(token.PLUS, l.ch)
+		}
 	case ':':
 		tok = newToken(token.COLON, l.ch)
+	case '.':
+		if strings.HasPrefix(l.input[l.position:], "...") {
+			l.readChar()
+			l.readChar()
+			tok = token.Token{Type: token.ELLIPSIS, Literal: "..."}
+		} else {
+			tok = newToken(token.ILLEGAL, l.ch)
+		}
 	case '-':
-		tok = newToken(token.MINUS, l.ch)
+		if l.peekChar() == '=' {
+			l.readChar()
+			tok = token.Token{Type: token.MINUS_ASSIGN, Literal: "-="}
+		} else {
+			tok = newToken(token.MINUS, l.ch)
+		}
+	case '&':
+		if l.peekChar() == '&' {
+			l.readChar()
+			tok = token.Token{Type: token.AND, Literal: "&&"}
+		} else {
+			tok = newToken(token.AMPERSAND, l.ch)
+		}
+	case '|':
+		if l.peekChar() == '|' {
+			l.readChar()
+			tok = token.Token{Type: token.OR, Literal: "||"}
+		} else {
+			tok = newToken(token.PIPE, l.ch)
+		}
+	case '^':
+		tok = newToken(token.CARET, l.ch)
+	case '~':
+		tok = newToken(token.TILDE, l.ch)
+	case '%':
+		tok = newToken(token.PERCENT, l.ch)
 	case '!':
 		if l.peekChar() == '=' {
 			ch := l.ch
@@ -50,15 +131,38 @@ func (l *Lexer) NextToken() token.Token {
 			tok = newToken(token.BANG, l.ch)
 		}
 	case '/':
-		tok = newToken(token.SLASH, l.ch)
+		if l.peekChar() == '/' || l.peekChar() == '*' {
+			tok = l.readComment(pos)
+			if tok.Type == token.COMMENT && !l.emitComments {
+				return l.NextToken()
+			}
+			return tok
+		}
+		if l.peekChar() == '=' {
+			l.readChar()
+			tok = token.Token{Type: token.SLASH_ASSIGN, Literal: "/="}
+		} else {
+			tok = newToken(token.SLASH, l.ch)
+		}
 	case '*':
-		tok = newToken(token.ASTERISK, l.ch)
+		if l.peekChar() == '*' {
+			l.readChar()
+			tok = token.Token{Type: token.POWER, Literal: "**"}
+		} else if l.peekChar() == '=' {
+			l.readChar()
+			tok = token.Token{Type: token.ASTERISK_ASSIGN, Literal: "*="}
+		} else {
+			tok = newToken(token.ASTERISK, l.ch)
+		}
 	case '<':
 		if l.peekChar() == '=' {
 			ch := l.ch
 			l.readChar()
 			literal := string(ch) + string(l.ch)
 			tok = token.Token{Type: token.LE, Literal: literal}
+		} else if l.peekChar() == '<' {
+			l.readChar()
+			tok = token.Token{Type: token.SHIFT_LEFT, Literal: "<<"}
 		} else {
 			tok = newToken(token.LT, l.ch)
 		}
@@ -68,6 +172,9 @@ func (l *Lexer) NextToken() token.Token {
 			l.readChar()
 			literal := string(ch) + string(l.ch)
 			tok = token.Token{Type: token.GE, Literal: literal}
+		} else if l.peekChar() == '>' {
+			l.readChar()
+			tok = token.Token{Type: token.SHIFT_RIGHT, Literal: ">>"}
 		} else {
 			tok = newToken(token.GT, l.ch)
 		}
@@ -84,38 +191,210 @@ func (l *Lexer) NextToken() token.Token {
 	case ')':
 		tok = newToken(token.RPAREN, l.ch)
 	case '"':
-		tok.Type = token.STRING
-		tok.Literal = l.readString()
+		return l.readString(pos)
 	case 0:
 		tok.Literal = ""
 		tok.Type = token.EOF
+		tok.Pos, tok.End = pos, pos
+		return tok
 	default:
 		if isLetter(l.ch) {
 			tok.Literal = l.readIdentifier()
 			tok.Type = token.LookupIdent(tok.Literal)
-			return tok
+			return l.withSpan(tok, pos)
 		} else if isDigit(l.ch) {
-			tok.Type = token.INT
-			tok.Literal = l.readNumber()
-			return tok
+			return l.readNumber(pos)
 		} else {
 			tok = newToken(token.ILLEGAL, l.ch)
+			if l.ch == utf8.RuneError && l.readPosition-l.position == 1 {
+				tok.Literal = l.input[l.position:l.readPosition]
+				l.readChar()
+				tok = l.withSpan(tok, pos)
+				l.addError(tok, "invalid UTF-8 encoding")
+				return tok
+			}
 		}
 	}
 
 	l.readChar()
+	return l.withSpan(tok, pos)
+}
+
+// withSpan sets the token span from start up to the current char.
+func (l *Lexer) withSpan(tok token.Token, start token.Position) token.Token {
+	tok.Pos = start
+	tok.End = l.currentPosition()
 	return tok
 }
 
-func (l *Lexer) readString() string {
-	position :=  l.position + 1
+// afterCurrent returns the position immediately after the current char.
+func (l *Lexer) afterCurrent() token.Position {
+	return token.Position{
+		Filename:   l.filename,
+		Offset:     l.readPosition,
+		Line:       l.line,
+		Column:     l.readPosition - l.lineStart + 1,
+		RuneColumn: l.runeColumn + 1,
+	}
+}
+
+func (l *Lexer) currentPosition() token.Position {
+	return token.Position{
+		Filename:   l.filename,
+		Offset:     l.position,
+		Line:       l.line,
+		Column:     l.position - l.lineStart + 1,
+		RuneColumn: l.runeColumn,
+	}
+}
+
+// readString reads a double-quoted string literal and decodes its escape
+// sequences into the token literal:
+//
+//	\n \t \r \0 \\ \"  the usual control and quoting characters
+//	\xHH               the code point U+00HH
+//	\u{H...}           any Unicode code point, 1 to 6 hex digits
+//
+// An unterminated string or an invalid escape yields an ILLEGAL token whose
+// literal is the raw source.
+func (l *Lexer) readString(start token.Position) token.Token {
+	var out strings.Builder
+	var escapeErr *Error
+
 	for {
 		l.readChar()
-		if l.ch == '"' || l.ch == 0 {
+
+		if l.ch == 0 {
+			tok := token.Token{Type: token.ILLEGAL, Literal: l.input[start.Offset:l.position]}
+			tok = l.withSpan(tok, start)
+			l.addError(tok, "unterminated string")
+			return tok
+		}
+
+		if l.ch == '"' {
 			break
 		}
+
+		if l.ch != '\\' {
+			// copy the raw bytes so invalid UTF-8 survives unchanged
+			out.WriteString(l.input[l.position:l.readPosition])
+			continue
+		}
+
+		escapeStart := l.currentPosition()
+		l.readChar()
+		if msg, ok := l.readEscape(&out); !ok && escapeErr == nil {
+			escapeErr = &Error{Pos: escapeStart, End: l.afterCurrent(), Msg: msg}
+		}
 	}
-	return l.input[position:l.position]
+
+	l.readChar()
+
+	if escapeErr != nil {
+		tok := token.Token{Type: token.ILLEGAL, Literal: l.input[start.Offset:l.position]}
+		l.errors = append(l.errors, *escapeErr)
+		return l.withSpan(tok, start)
+	}
+
+	tok := token.Token{Type: token.STRING, Literal: out.String()}
+	return l.withSpan(tok, start)
+}
+
+// readEscape decodes the escape sequence whose first character after the
+// backslash is the current char, leaving the lexer on its last character.
+func (l *Lexer) readEscape(out *strings.Builder) (string, bool) {
+	switch l.ch {
+	case 'n':
+		out.WriteByte('\n')
+	case 't':
+		out.WriteByte('\t')
+	case 'r':
+		out.WriteByte('\r')
+	case '0':
+		out.WriteByte(0)
+	case '\\', '"':
+		out.WriteRune(l.ch)
+	case 'x':
+		value, digits := l.readHexDigits(2)
+		if digits != 2 {
+			return "invalid hex escape, want \\xHH", false
+		}
+		out.WriteRune(rune(value))
+	case 'u':
+		if l.peekChar() != '{' {
+			return "invalid unicode escape, want \\u{H...}", false
+		}
+		l.readChar()
+		value, digits := l.readHexDigits(6)
+		if digits == 0 || l.peekChar() != '}' {
+			return "invalid unicode escape, want \\u{H...}", false
+		}
+		l.readChar()
+		if !utf8.ValidRune(rune(value)) {
+			return "invalid unicode code point in escape", false
+		}
+		out.WriteRune(rune(value))
+	case 0:
+		return "unterminated escape sequence", false
+	default:
+		return "unknown escape sequence \\" + string(l.ch), false
+	}
+
+	return "", true
+}
+
+// readHexDigits consumes up to max hex digits following the current char.
+func (l *Lexer) readHexDigits(max int) (int64, int) {
+	var value int64
+	digits := 0
+
+	for digits < max && isHexDigit(l.peekChar()) {
+		l.readChar()
+		value = value*16 + hexValue(l.ch)
+		digits++
+	}
+
+	return value, digits
+}
+
+// readComment reads a // line comment or a nestable /* */ block comment.
+// An unterminated block comment yields an ILLEGAL token.
+func (l *Lexer) readComment(start token.Position) token.Token {
+	if l.peekChar() == '/' {
+		for l.ch != '\n' && l.ch != 0 {
+			l.readChar()
+		}
+		tok := token.Token{Type: token.COMMENT, Literal: l.input[start.Offset:l.position]}
+		return l.withSpan(tok, start)
+	}
+
+	l.readChar()
+	l.readChar()
+
+	depth := 1
+	for depth > 0 {
+		switch {
+		case l.ch == 0:
+			tok := token.Token{Type: token.ILLEGAL, Literal: l.input[start.Offset:l.position]}
+			tok = l.withSpan(tok, start)
+			l.addError(tok, "unterminated block comment")
+			return tok
+		case l.ch == '/' && l.peekChar() == '*':
+			depth++
+			l.readChar()
+		case l.ch == '*' && l.peekChar() == '/':
+			depth--
+			l.readChar()
+		}
+		l.readChar()
+	}
+
+	tok := token.Token{Type: token.COMMENT, Literal: l.input[start.Offset:l.position]}
+	return l.withSpan(tok, start)
+}
+
+func (l *Lexer) addError(tok token.Token, msg string) {
+	l.errors = append(l.errors, Error{Pos: tok.Pos, End: tok.End, Msg: msg})
 }
 
 func (l *Lexer) skipWhitespace() {
@@ -125,47 +404,208 @@ func (l *Lexer) skipWhitespace() {
 }
 
 func (l *Lexer) readChar() {
+	if l.ch == '\n' {
+		l.line += 1
+		l.lineStart = l.readPosition
+		l.runeColumn = 1
+	} else {
+		l.runeColumn += 1
+	}
+
+	width := 1
 	if l.readPosition >= len(l.input) {
 		l.ch = 0
 	} else {
-		l.ch = l.input[l.readPosition]
+		l.ch, width = utf8.DecodeRuneInString(l.input[l.readPosition:])
 	}
 	l.position = l.readPosition
-	l.readPosition += 1
+	l.readPosition += width
 }
 
-func (l *Lexer) peekChar() byte {
+func (l *Lexer) peekChar() rune {
 	if l.readPosition >= len(l.input) {
 		return 0
 	} else {
-		return l.input[l.readPosition]
+		ch, _ := utf8.DecodeRuneInString(l.input[l.readPosition:])
+		return ch
 	}
 }
 
+// readIdentifier reads an identifier: a letter followed by any number of
+// letters and digits. Letters are '_' and anything unicode.IsLetter accepts,
+// digits are anything unicode.IsDigit accepts, so `π`, `größe` and `x٣` are
+// all valid identifiers.
 func (l *Lexer) readIdentifier() string {
 	position := l.position
-	for isLetter(l.ch) {
+	for isLetter(l.ch) || unicode.IsDigit(l.ch) {
 		l.readChar()
 	}
 	return l.input[position:l.position]
 }
 
-func (l *Lexer) readNumber() string {
-	position := l.position
-	for isDigit(l.ch) {
+// readNumber reads an INT or a FLOAT literal. Floats have a fraction
+// (`1.5`), an exponent (`1e9`) or both (`2.5E-3`); a fraction needs digits
+// on both sides of the dot so `1.` is not a float. Integers may also be
+// written in hexadecimal (`0xFF`), octal (`0o17`) or binary (`0b1010`), and
+// any literal may use '_' between digits (`1_000_000`, `0x_FF`).
+func (l *Lexer) readNumber(start token.Position) token.Token {
+	if l.ch == '0' && isBasePrefix(l.peekChar()) {
+		return l.readPrefixedInteger(start)
+	}
+
+	tok := token.Token{Type: token.INT}
+
+	l.readDigits()
+
+	if l.ch == '.' && isDigit(l.peekChar()) {
+		tok.Type = token.FLOAT
 		l.readChar()
+		l.readDigits()
 	}
-	return l.input[position:l.position]
+
+	if l.ch == 'e' || l.ch == 'E' {
+		tok.Type = token.FLOAT
+		l.readChar()
+		if l.ch == '+' || l.ch == '-' {
+			l.readChar()
+		}
+		if !isDigit(l.ch) {
+			return l.illegalNumber(start, "exponent has no digits")
+		}
+		l.readDigits()
+	}
+
+	if isLetter(l.ch) {
+		ch := l.ch
+		l.skipAlphanumeric()
+		return l.illegalNumber(start, fmt.Sprintf("invalid character %q in number literal", ch))
+	}
+
+	tok.Literal = l.input[start.Offset:l.position]
+	if !validUnderscores(tok.Literal, isDigit) {
+		return l.illegalNumber(start, "'_' must separate successive digits")
+	}
+
+	return l.withSpan(tok, start)
 }
 
-func isLetter(ch byte) bool {
-	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_'
+// readPrefixedInteger reads a 0x, 0o or 0b integer literal. The whole
+// alphanumeric run is consumed so a bad digit doesn't split the literal.
+func (l *Lexer) readPrefixedInteger(start token.Position) token.Token {
+	l.readChar()
+	prefix := l.ch
+	l.readChar()
+	l.skipAlphanumeric()
+
+	literal := l.input[start.Offset:l.position]
+
+	var name string
+	var valid func(rune) bool
+	switch prefix {
+	case 'x', 'X':
+		name, valid = "hexadecimal", isHexDigit
+	case 'o', 'O':
+		name, valid = "octal", func(ch rune) bool { return '0' <= ch && ch <= '7' }
+	default:
+		name, valid = "binary", func(ch rune) bool { return ch == '0' || ch == '1' }
+	}
+
+	digits := 0
+	for _, ch := range literal[2:] {
+		if ch == '_' {
+			continue
+		}
+		if !valid(ch) {
+			return l.illegalNumber(start, fmt.Sprintf("invalid digit %q in %s literal", ch, name))
+		}
+		digits++
+	}
+
+	if digits == 0 {
+		return l.illegalNumber(start, name+" literal has no digits")
+	}
+
+	if !validUnderscores(literal, valid) {
+		return l.illegalNumber(start, "'_' must separate successive digits")
+	}
+
+	return l.withSpan(token.Token{Type: token.INT, Literal: literal}, start)
+}
+
+func (l *Lexer) readDigits() {
+	for isDigit(l.ch) || l.ch == '_' {
+		l.readChar()
+	}
+}
+
+func (l *Lexer) skipAlphanumeric() {
+	for isLetter(l.ch) || isDigit(l.ch) {
+		l.readChar()
+	}
+}
+
+// illegalNumber returns an ILLEGAL token for the number literal read so far.
+func (l *Lexer) illegalNumber(start token.Position, msg string) token.Token {
+	tok := token.Token{Type: token.ILLEGAL, Literal: l.input[start.Offset:l.position]}
+	tok = l.withSpan(tok, start)
+	l.addError(tok, msg)
+	return tok
+}
+
+func isBasePrefix(ch rune) bool {
+	switch ch {
+	case 'x', 'X', 'o', 'O', 'b', 'B':
+		return true
+	default:
+		return false
+	}
 }
 
-func isDigit(ch byte) bool {
+// validUnderscores reports whether every '_' in literal sits between two
+// digits, or right after a base prefix and before a digit.
+func validUnderscores(literal string, isValidDigit func(rune) bool) bool {
+	for i := 0; i < len(literal); i++ {
+		if literal[i] != '_' {
+			continue
+		}
+
+		afterPrefix := i == 2 && isBasePrefix(rune(literal[1]))
+		if !afterPrefix && (i == 0 || !isValidDigit(rune(literal[i-1]))) {
+			return false
+		}
+		if i+1 == len(literal) || !isValidDigit(rune(literal[i+1])) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isLetter(ch rune) bool {
+	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_' ||
+		ch >= utf8.RuneSelf && unicode.IsLetter(ch)
+}
+
+// isDigit only accepts ASCII digits, number literals are never Unicode.
+func isDigit(ch rune) bool {
 	return '0' <= ch && ch <= '9'
 }
 
-func newToken(tokenType token.TokenType, ch byte) token.Token {
+func isHexDigit(ch rune) bool {
+	return isDigit(ch) || 'a' <= ch && ch <= 'f' || 'A' <= ch && ch <= 'F'
+}
+
+func hexValue(ch rune) int64 {
+	switch {
+	case isDigit(ch):
+		return int64(ch - '0')
+	case 'a' <= ch && ch <= 'f':
+		return int64(ch-'a') + 10
+	default:
+		return int64(ch-'A') + 10
+	}
+}
+
+func newToken(tokenType token.TokenType, ch rune) token.Token {
 	return token.Token{Type: tokenType, Literal: string(ch)}
 }
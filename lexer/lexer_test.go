@@ -15,7 +15,7 @@ let add = fn(x, y) {
 };
 
 let result = add(five, ten);
-!-/*5;
+!-/ *5;
 5 < 10 > 5;
 
 if (5 < 10) {
@@ -32,6 +32,12 @@ if (5 < 10) {
 [1, 2];
 
 {"foo": "bar"}
+a && b || c;
+a % b ** c & d | e ^ ~f << g >> h;
+a += 1; a -= 2; a *= b; a /= c;
+while for in break continue
+match (x) { 1 => y }
+[a, ...b]
 `
 
 	tests := []struct {
@@ -124,6 +130,157 @@ if (5 < 10) {
 		{token.COLON, ":"},
 		{token.STRING, "bar"},
 		{token.RBRACE, "}"},
+		{token.IDENT, "a"},
+		{token.AND, "&&"},
+		{token.IDENT, "b"},
+		{token.OR, "||"},
+		{token.IDENT, "c"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "a"},
+		{token.PERCENT, "%"},
+		{token.IDENT, "b"},
+		{token.POWER, "**"},
+		{token.IDENT, "c"},
+		{token.AMPERSAND, "&"},
+		{token.IDENT, "d"},
+		{token.PIPE, "|"},
+		{token.IDENT, "e"},
+		{token.CARET, "^"},
+		{token.TILDE, "~"},
+		{token.IDENT, "f"},
+		{token.SHIFT_LEFT, "<<"},
+		{token.IDENT, "g"},
+		{token.SHIFT_RIGHT, ">>"},
+		{token.IDENT, "h"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "a"},
+		{token.PLUS_ASSIGN, "+="},
+		{token.INT, "1"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "a"},
+		{token.MINUS_ASSIGN, "-="},
+		{token.INT, "2"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "a"},
+		{token.ASTERISK_ASSIGN, "*="},
+		{token.IDENT, "b"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "a"},
+		{token.SLASH_ASSIGN, "/="},
+		{token.IDENT, "c"},
+		{token.SEMICOLON, ";"},
+		{token.WHILE, "while"},
+		{token.FOR, "for"},
+		{token.IN, "in"},
+		{token.BREAK, "break"},
+		{token.CONTINUE, "continue"},
+		{token.MATCH, "match"},
+		{token.LPAREN, "("},
+		{token.IDENT, "x"},
+		{token.RPAREN, ")"},
+		{token.LBRACE, "{"},
+		{token.INT, "1"},
+		{token.ARROW, "=>"},
+		{token.IDENT, "y"},
+		{token.RBRACE, "}"},
+		{token.LBRACKET, "["},
+		{token.IDENT, "a"},
+		{token.COMMA, ","},
+		{token.ELLIPSIS, "..."},
+		{token.IDENT, "b"},
+		{token.RBRACKET, "]"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func TestTokenPositions(t *testing.T) {
+	input := `let x = 5;
+  "ab" +
+x`
+
+	tests := []struct {
+		expectedType token.TokenType
+		expectedPos  token.Position
+		expectedEnd  token.Position
+	}{
+		{token.LET, token.Position{Offset: 0, Line: 1, Column: 1, RuneColumn: 1}, token.Position{Offset: 3, Line: 1, Column: 4, RuneColumn: 4}},
+		{token.IDENT, token.Position{Offset: 4, Line: 1, Column: 5, RuneColumn: 5}, token.Position{Offset: 5, Line: 1, Column: 6, RuneColumn: 6}},
+		{token.ASSIGN, token.Position{Offset: 6, Line: 1, Column: 7, RuneColumn: 7}, token.Position{Offset: 7, Line: 1, Column: 8, RuneColumn: 8}},
+		{token.INT, token.Position{Offset: 8, Line: 1, Column: 9, RuneColumn: 9}, token.Position{Offset: 9, Line: 1, Column: 10, RuneColumn: 10}},
+		{token.SEMICOLON, token.Position{Offset: 9, Line: 1, Column: 10, RuneColumn: 10}, token.Position{Offset: 10, Line: 1, Column: 11, RuneColumn: 11}},
+		{token.STRING, token.Position{Offset: 13, Line: 2, Column: 3, RuneColumn: 3}, token.Position{Offset: 17, Line: 2, Column: 7, RuneColumn: 7}},
+		{token.PLUS, token.Position{Offset: 18, Line: 2, Column: 8, RuneColumn: 8}, token.Position{Offset: 19, Line: 2, Column: 9, RuneColumn: 9}},
+		{token.IDENT, token.Position{Offset: 20, Line: 3, Column: 1, RuneColumn: 1}, token.Position{Offset: 21, Line: 3, Column: 2, RuneColumn: 2}},
+		{token.EOF, token.Position{Offset: 21, Line: 3, Column: 2, RuneColumn: 2}, token.Position{Offset: 21, Line: 3, Column: 2, RuneColumn: 2}},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Pos != tt.expectedPos {
+			t.Fatalf("tests[%d] - pos wrong. expected=%+v, got=%+v",
+				i, tt.expectedPos, tok.Pos)
+		}
+
+		if tok.End != tt.expectedEnd {
+			t.Fatalf("tests[%d] - end wrong. expected=%+v, got=%+v",
+				i, tt.expectedEnd, tok.End)
+		}
+	}
+}
+
+func TestTokenFilename(t *testing.T) {
+	l := NewWithFilename("main.mk", "\n  foo")
+
+	tok := l.NextToken()
+	if tok.Pos.String() != "main.mk:2:3" {
+		t.Fatalf("tok.Pos wrong. expected=%q, got=%q", "main.mk:2:3", tok.Pos.String())
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `// leading comment
+let x = 5; // trailing
+/* block /* nested */ still comment */ x / 2;
+/**/x`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.LET, "let"},
+		{token.IDENT, "x"},
+		{token.ASSIGN, "="},
+		{token.INT, "5"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "x"},
+		{token.SLASH, "/"},
+		{token.INT, "2"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "x"},
 		{token.EOF, ""},
 	}
 
@@ -143,3 +300,319 @@ if (5 < 10) {
 		}
 	}
 }
+
+func TestEmitComments(t *testing.T) {
+	input := `// one
+x /* two
+ lines */`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+		expectedPos     string
+		expectedEnd     string
+	}{
+		{token.COMMENT, "// one", "1:1", "1:7"},
+		{token.IDENT, "x", "2:1", "2:2"},
+		{token.COMMENT, "/* two\n lines */", "2:3", "3:10"},
+		{token.EOF, "", "3:10", "3:10"},
+	}
+
+	l := New(input)
+	l.EmitComments(true)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+
+		if tok.Pos.String() != tt.expectedPos || tok.End.String() != tt.expectedEnd {
+			t.Fatalf("tests[%d] - span wrong. expected=%s-%s, got=%s-%s",
+				i, tt.expectedPos, tt.expectedEnd, tok.Pos, tok.End)
+		}
+	}
+}
+
+func TestUnterminatedBlockComment(t *testing.T) {
+	l := New("x /* never /* closed */")
+
+	l.NextToken()
+	tok := l.NextToken()
+
+	if tok.Type != token.ILLEGAL {
+		t.Fatalf("tokentype wrong. expected=%q, got=%q", token.ILLEGAL, tok.Type)
+	}
+
+	errors := l.Errors()
+	if len(errors) != 1 {
+		t.Fatalf("expected 1 lexer error, got=%d", len(errors))
+	}
+	if errors[0].Msg != "unterminated block comment" || errors[0].Pos.String() != "1:3" {
+		t.Errorf("wrong lexer error. got=%+v", errors[0])
+	}
+}
+
+func TestStringEscapes(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`"a\"b"`, `a"b`},
+		{`"line\nnext\ttab\rret"`, "line\nnext\ttab\rret"},
+		{`"back\\slash"`, `back\slash`},
+		{`"nul\0"`, "nul\x00"},
+		{`"\x41\x7e\xe9"`, "A~é"},
+		{`"\u{41}\u{1F600}"`, "A😀"},
+		{`""`, ""},
+	}
+
+	for _, tt := range tests {
+		l := New(tt.input)
+		tok := l.NextToken()
+
+		if tok.Type != token.STRING {
+			t.Errorf("%s: tokentype wrong. expected=%q, got=%q (%v)",
+				tt.input, token.STRING, tok.Type, l.Errors())
+			continue
+		}
+
+		if tok.Literal != tt.expected {
+			t.Errorf("%s: literal wrong. expected=%q, got=%q",
+				tt.input, tt.expected, tok.Literal)
+		}
+
+		if tok.End.Offset != len(tt.input) {
+			t.Errorf("%s: token does not end after the closing quote. got=%d",
+				tt.input, tok.End.Offset)
+		}
+	}
+}
+
+func TestInvalidStrings(t *testing.T) {
+	tests := []struct {
+		input       string
+		expectedMsg string
+		expectedPos string
+		expectedEnd string
+	}{
+		{`"abc`, "unterminated string", "1:1", "1:7"},
+		{`"abc\`, "unterminated string", "1:1", "1:8"},
+		{`"a\qb"`, `unknown escape sequence \q`, "1:3", "1:5"},
+		{`"\xZ1"`, `invalid hex escape, want \xHH`, "1:2", "1:4"},
+		{`"\u41"`, `invalid unicode escape, want \u{H...}`, "1:2", "1:4"},
+		{`"\u{41"`, `invalid unicode escape, want \u{H...}`, "1:2", "1:7"},
+		{`"\u{D800}"`, "invalid unicode code point in escape", "1:2", "1:10"},
+		{`"\u{110000}"`, "invalid unicode code point in escape", "1:2", "1:12"},
+	}
+
+	for _, tt := range tests {
+		l := New(tt.input + " x")
+		tok := l.NextToken()
+
+		if tok.Type != token.ILLEGAL {
+			t.Errorf("%s: tokentype wrong. expected=%q, got=%q",
+				tt.input, token.ILLEGAL, tok.Type)
+			continue
+		}
+
+		errors := l.Errors()
+		if len(errors) != 1 {
+			t.Errorf("%s: expected 1 lexer error, got=%d", tt.input, len(errors))
+			continue
+		}
+
+		err := errors[0]
+		if err.Msg != tt.expectedMsg {
+			t.Errorf("%s: wrong message. expected=%q, got=%q",
+				tt.input, tt.expectedMsg, err.Msg)
+		}
+		if err.Pos.String() != tt.expectedPos || err.End.String() != tt.expectedEnd {
+			t.Errorf("%s: wrong span. expected=%s-%s, got=%s-%s",
+				tt.input, tt.expectedPos, tt.expectedEnd, err.Pos, err.End)
+		}
+	}
+}
+
+func TestUnicode(t *testing.T) {
+	input := `let größe = "ünï😀"; π_2 + x٣ €`
+
+	tests := []struct {
+		expectedType       token.TokenType
+		expectedLiteral    string
+		expectedColumn     int
+		expectedRuneColumn int
+	}{
+		{token.LET, "let", 1, 1},
+		{token.IDENT, "größe", 5, 5},
+		{token.ASSIGN, "=", 13, 11},
+		{token.STRING, "ünï😀", 15, 13},
+		{token.SEMICOLON, ";", 26, 19},
+		{token.IDENT, "π_2", 28, 21},
+		{token.PLUS, "+", 33, 25},
+		{token.IDENT, "x٣", 35, 27},
+		{token.ILLEGAL, "€", 39, 30},
+		{token.EOF, "", 42, 31},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+
+		if tok.Pos.Column != tt.expectedColumn || tok.Pos.RuneColumn != tt.expectedRuneColumn {
+			t.Fatalf("tests[%d] - columns wrong. expected=%d/%d, got=%d/%d",
+				i, tt.expectedColumn, tt.expectedRuneColumn,
+				tok.Pos.Column, tok.Pos.RuneColumn)
+		}
+	}
+}
+
+func TestInvalidUTF8(t *testing.T) {
+	l := New("x \xff y")
+
+	tests := []token.TokenType{token.IDENT, token.ILLEGAL, token.IDENT, token.EOF}
+	for i, expected := range tests {
+		tok := l.NextToken()
+		if tok.Type != expected {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, expected, tok.Type)
+		}
+	}
+
+	errors := l.Errors()
+	if len(errors) != 1 || errors[0].Msg != "invalid UTF-8 encoding" {
+		t.Fatalf("wrong lexer errors. got=%+v", errors)
+	}
+	if errors[0].Pos.String() != "1:3" || errors[0].End.String() != "1:4" {
+		t.Errorf("wrong span. got=%s-%s", errors[0].Pos, errors[0].End)
+	}
+}
+
+func TestNumbers(t *testing.T) {
+	input := `5 3.14 0.5 1e9 2.5E-3 7e+2 1.foo 4e`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.INT, "5"},
+		{token.FLOAT, "3.14"},
+		{token.FLOAT, "0.5"},
+		{token.FLOAT, "1e9"},
+		{token.FLOAT, "2.5E-3"},
+		{token.FLOAT, "7e+2"},
+		{token.INT, "1"},
+		{token.ILLEGAL, "."},
+		{token.IDENT, "foo"},
+		{token.ILLEGAL, "4e"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+
+	errors := l.Errors()
+	if len(errors) != 1 || errors[0].Msg != "exponent has no digits" {
+		t.Errorf("wrong lexer errors. got=%+v", errors)
+	}
+}
+
+func TestIntegerLiteralForms(t *testing.T) {
+	input := `0xFF 0Xab_cd 0o17 0b1010 0b_1 1_000_000 3.141_592 1e1_0`
+
+	tests := []string{"0xFF", "0Xab_cd", "0o17", "0b1010", "0b_1", "1_000_000", "3.141_592", "1e1_0"}
+
+	l := New(input)
+
+	for i, expected := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != token.INT && tok.Type != token.FLOAT {
+			t.Fatalf("tests[%d] - tokentype wrong. expected a number, got=%q (%v)",
+				i, tok.Type, l.Errors())
+		}
+
+		if tok.Literal != expected {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, expected, tok.Literal)
+		}
+	}
+}
+
+func TestMalformedNumbers(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedLiteral string
+		expectedMsg     string
+	}{
+		{"0x", "0x", "hexadecimal literal has no digits"},
+		{"0b_", "0b_", "binary literal has no digits"},
+		{"0xFG", "0xFG", `invalid digit 'G' in hexadecimal literal`},
+		{"0o78", "0o78", `invalid digit '8' in octal literal`},
+		{"0b102", "0b102", `invalid digit '2' in binary literal`},
+		{"1__000", "1__000", "'_' must separate successive digits"},
+		{"1000_", "1000_", "'_' must separate successive digits"},
+		{"0x_FF_", "0x_FF_", "'_' must separate successive digits"},
+		{"1_.5", "1_.5", "'_' must separate successive digits"},
+		{"12abc", "12abc", `invalid character 'a' in number literal`},
+		{"3.5x", "3.5x", `invalid character 'x' in number literal`},
+	}
+
+	for _, tt := range tests {
+		l := New(tt.input + ";")
+		tok := l.NextToken()
+
+		if tok.Type != token.ILLEGAL {
+			t.Errorf("%s: tokentype wrong. expected=%q, got=%q",
+				tt.input, token.ILLEGAL, tok.Type)
+			continue
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Errorf("%s: literal wrong. expected=%q, got=%q",
+				tt.input, tt.expectedLiteral, tok.Literal)
+		}
+
+		errors := l.Errors()
+		if len(errors) != 1 || errors[0].Msg != tt.expectedMsg {
+			t.Errorf("%s: wrong lexer errors. expected=%q, got=%+v",
+				tt.input, tt.expectedMsg, errors)
+		}
+
+		if next := l.NextToken(); next.Type != token.SEMICOLON {
+			t.Errorf("%s: lexing did not resume after the literal. got=%q",
+				tt.input, next.Type)
+		}
+	}
+}
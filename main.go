@@ -8,6 +8,11 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		runFile(os.Args[1])
+		return
+	}
+
 	user, err := user.Current()
 	if err != nil {
 		panic(err)
@@ -17,3 +22,16 @@ func main() {
 	fmt.Printf("Feel free to type in commands\n")
 	repl.Start(os.Stdin, os.Stdout)
 }
+
+// runFile runs the script at path, exiting with a non-zero status when it
+// cannot be read, does not parse or fails at runtime.
+func runFile(path string) {
+	source, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if !repl.Run(path, string(source), os.Stderr) {
+		os.Exit(1)
+	}
+}
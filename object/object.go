@@ -4,7 +4,11 @@ import (
 	"bytes"
 	"fmt"
 	"hash/fnv"
+	"math"
+	"math/big"
 	"monkey/ast"
+	"monkey/token"
+	"strconv"
 	"strings"
 )
 
@@ -17,15 +21,21 @@ const (
 	ERROR_OBJ = "ERROR"
 
 	INTEGER_OBJ = "INTEGER"
+	BIGINT_OBJ  = "BIGINT"
+	FLOAT_OBJ   = "FLOAT"
 	BOOLEAN_OBJ = "BOOLEAN"
 	STRING_OBJ  = "STRING"
 
 	RETURN_VALUE_OBJ = "RETURN_VALUE"
+	BREAK_OBJ        = "BREAK"
+	CONTINUE_OBJ     = "CONTINUE"
+	TAIL_CALL_OBJ    = "TAIL_CALL"
 
 	FUNCTION_OBJ = "FUNCTION"
 	BUILTINT_OBJ = "BUILTIN"
 	ARRAY_OBJ    = "ARRAY"
 	HASH_OBJ    = "HASH"
+	RANGE_OBJ    = "RANGE"
 )
 
 type Object interface {
@@ -40,6 +50,32 @@ type Integer struct {
 func (i *Integer) Type() ObjectType { return INTEGER_OBJ }
 func (i *Integer) Inspect() string  { return fmt.Sprintf("%d", i.Value) }
 
+// BigInt is an integer outside the int64 range. Integer arithmetic promotes
+// to a BigInt on overflow and demotes back once the result fits, so a BigInt
+// never holds a value an Integer could.
+type BigInt struct {
+	Value *big.Int
+}
+
+func (b *BigInt) Type() ObjectType { return BIGINT_OBJ }
+func (b *BigInt) Inspect() string  { return b.Value.String() }
+
+type Float struct {
+	Value float64
+}
+
+func (f *Float) Type() ObjectType { return FLOAT_OBJ }
+
+// Inspect always shows a fraction or an exponent so floats can't be
+// mistaken for integers, e.g. 2.0 rather than 2.
+func (f *Float) Inspect() string {
+	s := strconv.FormatFloat(f.Value, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eIN") {
+		s += ".0"
+	}
+	return s
+}
+
 type Boolean struct {
 	Value bool
 }
@@ -59,15 +95,85 @@ type ReturnValue struct {
 func (rv *ReturnValue) Type() ObjectType { return RETURN_VALUE_OBJ }
 func (rv *ReturnValue) Inspect() string  { return rv.Value.Inspect() }
 
+// Break and Continue unwind the enclosing blocks up to the innermost loop,
+// the same way ReturnValue unwinds up to the function.
+type Break struct{}
+
+func (b *Break) Type() ObjectType { return BREAK_OBJ }
+func (b *Break) Inspect() string  { return "break" }
+
+type Continue struct{}
+
+func (c *Continue) Type() ObjectType { return CONTINUE_OBJ }
+func (c *Continue) Inspect() string  { return "continue" }
+
+// TailCall is a call in tail position, returned unevaluated by the body of
+// a function so the caller runs it in place of the finished call.
+type TailCall struct {
+	Function  Object
+	Arguments []Object
+	Call      *ast.CallExpression
+}
+
+func (tc *TailCall) Type() ObjectType { return TAIL_CALL_OBJ }
+func (tc *TailCall) Inspect() string  { return "tail call " + tc.Call.String() }
+
 type Error struct {
 	Message string
+	Pos     token.Position // where the error was raised, if known
+	Cause   error          // why the evaluation was stopped, if it was
+	Stack   []Frame        // the calls the error went through, innermost first
+}
+
+// Frame is a function call an error propagated out of.
+type Frame struct {
+	Function string         // the name of the called function, empty if anonymous
+	Pos      token.Position // the call site
 }
 
 func (e *Error) Type() ObjectType { return ERROR_OBJ }
-func (e *Error) Inspect() string  { return "ERROR: " + e.Message }
+func (e *Error) Inspect() string {
+	if e.Pos.IsValid() {
+		return "ERROR: " + e.Pos.String() + ": " + e.Message
+	}
+	return "ERROR: " + e.Message
+}
+
+// Traceback renders the stack of e, outermost call first, followed by
+// Inspect. Runs of identical frames, as left by recursion, are collapsed.
+func (e *Error) Traceback() string {
+	if len(e.Stack) == 0 {
+		return e.Inspect()
+	}
+
+	var out bytes.Buffer
+	out.WriteString("Traceback (most recent call last):\n")
+	for i := len(e.Stack) - 1; i >= 0; {
+		frame := e.Stack[i]
+		repeated := 0
+		for i--; i >= 0 && e.Stack[i] == frame; i-- {
+			repeated++
+		}
+
+		name := frame.Function
+		if name == "" {
+			name = "anonymous function"
+		}
+		out.WriteString("  " + frame.Pos.String() + ": in call to " + name + "\n")
+		if repeated > 0 {
+			out.WriteString(fmt.Sprintf("  [previous frame repeated %d more times]\n", repeated))
+		}
+	}
+	out.WriteString(e.Inspect())
+
+	return out.String()
+}
 
 type Function struct {
+	Name       string // empty for anonymous functions
 	Parameters []*ast.Identifier
+	Defaults   map[string]ast.Expression // default values by parameter name
+	Rest       *ast.Identifier           // the ...rest parameter, or nil
 	Body       *ast.BlockStatement
 	Env        *Environment
 }
@@ -78,10 +184,20 @@ func (f *Function) Inspect() string {
 
 	params := []string{}
 	for _, p := range f.Parameters {
-		params = append(params, p.String())
+		if def, ok := f.Defaults[p.Value]; ok {
+			params = append(params, p.String()+" = "+def.String())
+		} else {
+			params = append(params, p.String())
+		}
+	}
+	if f.Rest != nil {
+		params = append(params, "..."+f.Rest.String())
 	}
 
 	out.WriteString("fn")
+	if f.Name != "" {
+		out.WriteString(" " + f.Name)
+	}
 	out.WriteString("(")
 	out.WriteString(strings.Join(params, ", "))
 	out.WriteString(") {\n")
@@ -91,6 +207,7 @@ func (f *Function) Inspect() string {
 	return out.String()
 }
 
+// String holds UTF-8 encoded text. Its length is counted in runes.
 type String struct {
 	Value string
 }
@@ -105,6 +222,10 @@ func (s *String) Inspect() string {
 
 type Builtin struct {
 	Fn BuiltinFunction
+	// Allocates is set when Fn builds the strings, arrays or hashes it
+	// returns rather than returning ones it was passed, so that the
+	// evaluator charges them against the memory quota.
+	Allocates bool
 }
 
 func (b *Builtin) Type() ObjectType {
@@ -138,6 +259,25 @@ func (a *Array) Inspect() string {
 	return out.String()
 }
 
+// Range is the sequence of integers from Start up to, but not including,
+// Stop, counting by Step. Its elements are produced lazily while iterating.
+type Range struct {
+	Start int64
+	Stop  int64
+	Step  int64 // never 0
+}
+
+func (r *Range) Type() ObjectType {
+	return RANGE_OBJ
+}
+
+func (r *Range) Inspect() string {
+	if r.Step == 1 {
+		return fmt.Sprintf("range(%d, %d)", r.Start, r.Stop)
+	}
+	return fmt.Sprintf("range(%d, %d, %d)", r.Start, r.Stop, r.Step)
+}
+
 type HashKey struct {
 	Type ObjectType
 	Value uint64
@@ -164,6 +304,29 @@ func (i *Integer) HashKey() HashKey {
 	return HashKey{Type: i.Type(), Value: uint64(i.Value)}
 }
 
+func (b *BigInt) HashKey() HashKey {
+	h := fnv.New64a()
+	if b.Value.Sign() < 0 {
+		h.Write([]byte{'-'})
+	}
+	h.Write(b.Value.Bytes())
+
+	return HashKey{Type: b.Type(), Value: h.Sum64()}
+}
+
+// HashKey hashes integral floats like the equal Integer or BigInt, so 1.0
+// and 1 are the same hash key, as they compare equal.
+func (f *Float) HashKey() HashKey {
+	if f.Value == math.Trunc(f.Value) && !math.IsInf(f.Value, 0) {
+		if math.Abs(f.Value) < 1<<63 {
+			return (&Integer{Value: int64(f.Value)}).HashKey()
+		}
+		value, _ := big.NewFloat(f.Value).Int(nil)
+		return (&BigInt{Value: value}).HashKey()
+	}
+	return HashKey{Type: f.Type(), Value: math.Float64bits(f.Value)}
+}
+
 func (s *String) HashKey() HashKey {
 	h := fnv.New64a()
 	h.Write([]byte(s.Value))
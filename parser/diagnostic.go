@@ -0,0 +1,125 @@
+package parser
+
+import (
+	"bytes"
+	"monkey/token"
+	"strings"
+)
+
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	default:
+		return "unknown"
+	}
+}
+
+// Diagnostic codes, stable across releases so tools can match on them.
+const (
+	CodeUnexpectedToken   = "unexpected-token"
+	CodeNoPrefixParseFn   = "no-prefix-parse-fn"
+	CodeInvalidInteger    = "invalid-integer"
+	CodeInvalidFloat      = "invalid-float"
+	CodeIllegalToken      = "illegal-token"
+	CodeInvalidAssignment = "invalid-assignment"
+	CodeOutsideLoop       = "outside-loop"
+	CodeInvalidPattern    = "invalid-pattern"
+	CodeInvalidParameter  = "invalid-parameter"
+)
+
+// Diagnostic is a problem found while parsing, located by a source span.
+type Diagnostic struct {
+	Severity Severity
+	Code     string
+	Message  string
+	Pos      token.Position // start of the offending span
+	End      token.Position // end of the offending span
+
+	// Expected and Got are only set for CodeUnexpectedToken.
+	Expected token.TokenType
+	Got      token.TokenType
+
+	Hint string // optional suggestion, empty when there is none
+}
+
+// String returns the diagnostic in the "line:column: message" form that
+// Parser.Errors returns.
+func (d Diagnostic) String() string {
+	return d.Pos.String() + ": " + d.Message
+}
+
+// Render formats the diagnostic with the offending source line and a caret
+// underline below the span, e.g.
+//
+//	1:5: error: expected next token to be IDENT, got = instead
+//	let = 5;
+//	    ^
+func (d Diagnostic) Render(source string) string {
+	var out bytes.Buffer
+
+	out.WriteString(d.Pos.String() + ": " + d.Severity.String() + ": " + d.Message + "\n")
+
+	if line, ok := sourceLine(source, d.Pos.Line); ok {
+		out.WriteString(line + "\n")
+		out.WriteString(underline(line, d.Pos, d.End) + "\n")
+	}
+
+	if d.Hint != "" {
+		out.WriteString("hint: " + d.Hint + "\n")
+	}
+
+	return out.String()
+}
+
+func sourceLine(source string, line int) (string, bool) {
+	if line < 1 {
+		return "", false
+	}
+
+	lines := strings.Split(source, "\n")
+	if line > len(lines) {
+		return "", false
+	}
+
+	return strings.TrimRight(lines[line-1], "\r"), true
+}
+
+// underline builds the caret line for line, keeping tabs so the carets stay
+// aligned with the source. Columns are counted in runes, and spans running
+// past the line are cut at its end.
+func underline(line string, start, end token.Position) string {
+	var out bytes.Buffer
+
+	runes := []rune(line)
+
+	from := start.RuneColumn - 1
+	to := from + 1
+	if end.Line == start.Line && end.RuneColumn > start.RuneColumn {
+		to = end.RuneColumn - 1
+	}
+	if to > len(runes) && from < len(runes) {
+		to = len(runes)
+	}
+
+	for i := 0; i < from; i++ {
+		if i < len(runes) && runes[i] == '\t' {
+			out.WriteByte('\t')
+		} else {
+			out.WriteByte(' ')
+		}
+	}
+
+	out.WriteString(strings.Repeat("^", to-from))
+
+	return out.String()
+}
@@ -1,7 +1,9 @@
 package parser
 
 import (
+	"errors"
 	"fmt"
+	"math/big"
 	"monkey/ast"
 	"monkey/lexer"
 	"monkey/token"
@@ -11,24 +13,51 @@ import (
 const (
 	_ int = iota
 	LOWEST
+	ASSIGN      // = or +=, right associative
+	LOGICAL_OR  // ||
+	LOGICAL_AND // &&
 	EQUALS      // ==
-	LESSGREATER // > or <
+	LESSGREATER // >, <, >= or <=
+	BITWISE_OR  // |
+	BITWISE_XOR // ^
+	BITWISE_AND // &
+	SHIFT       // << or >>
 	SUM         // +
-	PRODUCT     // *
-	PREFIX      // -X or !X
+	PRODUCT     // *, / or %
+	PREFIX      // -X, !X or ~X
+	EXPONENT    // X ** Y, right associative
 	CALL        // myFunction(X)
 	INDEX
 )
 
 var precedences = map[token.TokenType]int{
+	token.ASSIGN:          ASSIGN,
+	token.PLUS_ASSIGN:     ASSIGN,
+	token.MINUS_ASSIGN:    ASSIGN,
+	token.ASTERISK_ASSIGN: ASSIGN,
+	token.SLASH_ASSIGN:    ASSIGN,
+
+	token.OR:       LOGICAL_OR,
+	token.AND:      LOGICAL_AND,
 	token.EQ:       EQUALS,
 	token.NOT_EQ:   EQUALS,
 	token.LT:       LESSGREATER,
 	token.GT:       LESSGREATER,
+	token.LE:       LESSGREATER,
+	token.GE:       LESSGREATER,
 	token.PLUS:     SUM,
 	token.MINUS:    SUM,
 	token.SLASH:    PRODUCT,
 	token.ASTERISK: PRODUCT,
+	token.PERCENT:  PRODUCT,
+	token.POWER:    EXPONENT,
+
+	token.PIPE:        BITWISE_OR,
+	token.CARET:       BITWISE_XOR,
+	token.AMPERSAND:   BITWISE_AND,
+	token.SHIFT_LEFT:  SHIFT,
+	token.SHIFT_RIGHT: SHIFT,
+
 	token.LPAREN:   CALL,
 	token.LBRACKET: INDEX,
 }
@@ -39,45 +68,73 @@ type (
 )
 
 type Parser struct {
-	l      *lexer.Lexer
-	errors []string
+	l           *lexer.Lexer
+	diagnostics []Diagnostic
 
 	curToken  token.Token
 	peekToken token.Token
 
+	// Panic-mode recovery: after the first error in a statement further
+	// errors are suppressed until the parser resynchronizes.
+	panicking  bool
+	braceDepth int // number of { opened up to and including curToken
+	blockDepth int // braceDepth of the innermost enclosing block statement
+
+	loopDepth int // number of loops enclosing curToken in the current function
+
 	prefixParseFns map[token.TokenType]prefixParseFn
 	infixParseFns  map[token.TokenType]infixParseFn
 }
 
 func New(l *lexer.Lexer) *Parser {
 	p := &Parser{
-		l:      l,
-		errors: []string{},
+		l:           l,
+		diagnostics: []Diagnostic{},
 	}
 
 	p.prefixParseFns = make(map[token.TokenType]prefixParseFn)
 	p.registerPrefix(token.IDENT, p.parseIdentifier)
 	p.registerPrefix(token.INT, p.parseIntegerLiteral)
+	p.registerPrefix(token.FLOAT, p.parseFloatLiteral)
 	p.registerPrefix(token.BANG, p.parsePrefixExpression)
 	p.registerPrefix(token.MINUS, p.parsePrefixExpression)
+	p.registerPrefix(token.TILDE, p.parsePrefixExpression)
 	p.registerPrefix(token.TRUE, p.parseBoolean)
 	p.registerPrefix(token.FALSE, p.parseBoolean)
 	p.registerPrefix(token.LPAREN, p.parseGroupedExpression)
 	p.registerPrefix(token.IF, p.parseIfExpression)
+	p.registerPrefix(token.MATCH, p.parseMatchExpression)
 	p.registerPrefix(token.FUNCTION, p.parseFunctionLiteral)
 	p.registerPrefix(token.STRING, p.parseStringLiteral)
 	p.registerPrefix(token.LBRACKET, p.parseArrayLiteral)
 	p.registerPrefix(token.LBRACE, p.parseHashLiteral)
+	p.registerPrefix(token.ILLEGAL, p.parseIllegal)
 
 	p.infixParseFns = make(map[token.TokenType]infixParseFn)
+	p.registerInfix(token.ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.PLUS_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.MINUS_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.ASTERISK_ASSIGN, p.parseAssignExpression)
+	p.registerInfix(token.SLASH_ASSIGN, p.parseAssignExpression)
 	p.registerInfix(token.PLUS, p.parseInfixExpression)
 	p.registerInfix(token.MINUS, p.parseInfixExpression)
 	p.registerInfix(token.SLASH, p.parseInfixExpression)
 	p.registerInfix(token.ASTERISK, p.parseInfixExpression)
+	p.registerInfix(token.PERCENT, p.parseInfixExpression)
+	p.registerInfix(token.POWER, p.parseInfixExpression)
+	p.registerInfix(token.PIPE, p.parseInfixExpression)
+	p.registerInfix(token.CARET, p.parseInfixExpression)
+	p.registerInfix(token.AMPERSAND, p.parseInfixExpression)
+	p.registerInfix(token.SHIFT_LEFT, p.parseInfixExpression)
+	p.registerInfix(token.SHIFT_RIGHT, p.parseInfixExpression)
 	p.registerInfix(token.EQ, p.parseInfixExpression)
 	p.registerInfix(token.NOT_EQ, p.parseInfixExpression)
 	p.registerInfix(token.LT, p.parseInfixExpression)
 	p.registerInfix(token.GT, p.parseInfixExpression)
+	p.registerInfix(token.LE, p.parseInfixExpression)
+	p.registerInfix(token.GE, p.parseInfixExpression)
+	p.registerInfix(token.AND, p.parseInfixExpression)
+	p.registerInfix(token.OR, p.parseInfixExpression)
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
 	p.registerInfix(token.LBRACKET, p.parseIndexExpression)
 
@@ -114,6 +171,8 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 		return nil
 	}
 
+	hash.EndPos = p.curToken.End
+
 	return hash
 }
 
@@ -127,9 +186,36 @@ func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 		return nil
 	}
 
+	exp.EndPos = p.curToken.End
+
 	return exp
 }
 
+// parseIllegal reports an ILLEGAL token, using the lexer's explanation and
+// its more precise span when it has one.
+func (p *Parser) parseIllegal() ast.Expression {
+	d := Diagnostic{
+		Severity: SeverityError,
+		Code:     CodeIllegalToken,
+		Message:  fmt.Sprintf("illegal token %q", p.curToken.Literal),
+		Pos:      p.curToken.Pos,
+		End:      p.curToken.End,
+		Got:      token.ILLEGAL,
+	}
+
+	for _, err := range p.l.Errors() {
+		offset := err.Pos.Offset
+		if offset >= p.curToken.Pos.Offset && offset < p.curToken.End.Offset {
+			d.Message, d.Pos, d.End = err.Msg, err.Pos, err.End
+			break
+		}
+	}
+
+	p.addDiagnostic(d)
+
+	return nil
+}
+
 func (p *Parser) parseStringLiteral() ast.Expression {
 	return &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
 }
@@ -137,6 +223,18 @@ func (p *Parser) parseStringLiteral() ast.Expression {
 func (p *Parser) nextToken() {
 	p.curToken = p.peekToken
 	p.peekToken = p.l.NextToken()
+	for p.peekToken.Type == token.COMMENT {
+		p.peekToken = p.l.NextToken()
+	}
+
+	switch p.curToken.Type {
+	case token.LBRACE:
+		p.braceDepth++
+	case token.RBRACE:
+		if p.braceDepth > 0 {
+			p.braceDepth--
+		}
+	}
 }
 
 func (p *Parser) curTokenIs(t token.TokenType) bool {
@@ -157,19 +255,66 @@ func (p *Parser) expectPeek(t token.TokenType) bool {
 	}
 }
 
+func (p *Parser) Diagnostics() []Diagnostic {
+	return p.diagnostics
+}
+
+// Errors returns the messages of the diagnostics, the form parser errors
+// had before they carried positions. Diagnostic.String and Render include
+// the position.
 func (p *Parser) Errors() []string {
-	return p.errors
+	errors := make([]string, 0, len(p.diagnostics))
+	for _, d := range p.diagnostics {
+		errors = append(errors, d.Message)
+	}
+	return errors
+}
+
+func (p *Parser) addDiagnostic(d Diagnostic) {
+	if d.Severity == SeverityError {
+		if p.panicking {
+			return
+		}
+		p.panicking = true
+	}
+	p.diagnostics = append(p.diagnostics, d)
 }
 
 func (p *Parser) peekError(t token.TokenType) {
-	msg := fmt.Sprintf("expected next token to be %s, got %s instead",
-		t, p.peekToken.Type)
-	p.errors = append(p.errors, msg)
+	d := Diagnostic{
+		Severity: SeverityError,
+		Code:     CodeUnexpectedToken,
+		Message: fmt.Sprintf("expected next token to be %s, got %s instead",
+			t, p.peekToken.Type),
+		Pos:      p.peekToken.Pos,
+		End:      p.peekToken.End,
+		Expected: t,
+		Got:      p.peekToken.Type,
+	}
+
+	switch t {
+	case token.RPAREN, token.RBRACKET, token.RBRACE, token.SEMICOLON:
+		d.Hint = fmt.Sprintf("missing '%s'?", t)
+	}
+
+	p.addDiagnostic(d)
 }
 
 func (p *Parser) noPrefixParseFnError(t token.TokenType) {
-	msg := fmt.Sprintf("no prefix parse function for %s found", t)
-	p.errors = append(p.errors, msg)
+	d := Diagnostic{
+		Severity: SeverityError,
+		Code:     CodeNoPrefixParseFn,
+		Message:  fmt.Sprintf("no prefix parse function for %s found", t),
+		Pos:      p.curToken.Pos,
+		End:      p.curToken.End,
+		Got:      t,
+	}
+
+	if t == token.EOF {
+		d.Hint = "the input ended in the middle of an expression"
+	}
+
+	p.addDiagnostic(d)
 }
 
 func (p *Parser) ParseProgram() *ast.Program {
@@ -177,7 +322,7 @@ func (p *Parser) ParseProgram() *ast.Program {
 	program.Statements = []ast.Statement{}
 
 	for !p.curTokenIs(token.EOF) {
-		stmt := p.parseStatement()
+		stmt := p.parseStatementWithRecovery()
 		if stmt != nil {
 			program.Statements = append(program.Statements, stmt)
 		}
@@ -187,12 +332,76 @@ func (p *Parser) ParseProgram() *ast.Program {
 	return program
 }
 
+// parseStatementWithRecovery parses a statement and, if it contains a syntax
+// error, skips ahead to the next statement boundary and returns an
+// ast.BadStatement covering the skipped source instead.
+func (p *Parser) parseStatementWithRecovery() ast.Statement {
+	if p.panicking {
+		// an enclosing statement already failed and will recover itself
+		return p.parseStatement()
+	}
+
+	start := p.curToken
+	stmt := p.parseStatement()
+	if !p.panicking {
+		return stmt
+	}
+
+	p.synchronize()
+	p.panicking = false
+
+	return &ast.BadStatement{Token: start, EndPos: p.curToken.End}
+}
+
+// synchronize advances until curToken is the last token of the broken
+// statement: a ; in the current block, or the token before a statement
+// keyword, the } closing the current block, or EOF. Braces opened inside
+// the broken statement are skipped as a whole.
+func (p *Parser) synchronize() {
+	if p.braceDepth < p.blockDepth {
+		// the error was on the } closing the current block
+		return
+	}
+
+	for !p.curTokenIs(token.EOF) && !p.peekTokenIs(token.EOF) {
+		atBlockLevel := p.braceDepth == p.blockDepth
+
+		if atBlockLevel && p.curTokenIs(token.SEMICOLON) {
+			return
+		}
+
+		switch p.peekToken.Type {
+		case token.LET, token.RETURN, token.WHILE, token.FOR, token.BREAK, token.CONTINUE:
+			if atBlockLevel {
+				return
+			}
+		case token.RBRACE:
+			if atBlockLevel && p.blockDepth > 0 {
+				return
+			}
+		}
+
+		p.nextToken()
+	}
+}
+
 func (p *Parser) parseStatement() ast.Statement {
 	switch p.curToken.Type {
 	case token.LET:
 		return p.parseLetStatement()
 	case token.RETURN:
 		return p.parseReturnStatement()
+	case token.FUNCTION:
+		if p.peekTokenIs(token.IDENT) {
+			return p.parseFunctionStatement()
+		}
+		return p.parseExpressionStatement()
+	case token.WHILE:
+		return p.parseWhileStatement()
+	case token.FOR:
+		return p.parseForStatement()
+	case token.BREAK, token.CONTINUE:
+		return p.parseLoopControlStatement()
 	default:
 		return p.parseExpressionStatement()
 	}
@@ -201,11 +410,19 @@ func (p *Parser) parseStatement() ast.Statement {
 func (p *Parser) parseLetStatement() *ast.LetStatement {
 	stmt := &ast.LetStatement{Token: p.curToken}
 
-	if !p.expectPeek(token.IDENT) {
-		return nil
-	}
+	if p.peekTokenIs(token.LBRACKET) || p.peekTokenIs(token.LBRACE) {
+		p.nextToken()
+		stmt.Pattern = p.parsePattern()
+		if stmt.Pattern == nil {
+			return nil
+		}
+	} else {
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
 
-	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+		stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	}
 
 	if !p.expectPeek(token.ASSIGN) {
 		return nil
@@ -215,6 +432,10 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 
 	stmt.Value = p.parseExpression(LOWEST)
 
+	if fl, ok := stmt.Value.(*ast.FunctionLiteral); ok && stmt.Name != nil {
+		fl.Name = stmt.Name.Value
+	}
+
 	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
@@ -236,6 +457,105 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	return stmt
 }
 
+func (p *Parser) parseWhileStatement() ast.Statement {
+	stmt := &ast.WhileStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	p.nextToken()
+	stmt.Condition = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	stmt.Body = p.parseLoopBody()
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseForStatement() ast.Statement {
+	stmt := &ast.ForStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+
+	stmt.Variable = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	if !p.expectPeek(token.IN) {
+		return nil
+	}
+
+	p.nextToken()
+	stmt.Iterable = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	stmt.Body = p.parseLoopBody()
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseLoopBody() *ast.BlockStatement {
+	p.loopDepth++
+	defer func() { p.loopDepth-- }()
+
+	return p.parseBlockStatement()
+}
+
+// parseLoopControlStatement parses break and continue, which are only
+// allowed inside a loop of the current function.
+func (p *Parser) parseLoopControlStatement() ast.Statement {
+	var stmt ast.Statement
+	if p.curTokenIs(token.BREAK) {
+		stmt = &ast.BreakStatement{Token: p.curToken}
+	} else {
+		stmt = &ast.ContinueStatement{Token: p.curToken}
+	}
+
+	if p.loopDepth == 0 {
+		p.addDiagnostic(Diagnostic{
+			Severity: SeverityError,
+			Code:     CodeOutsideLoop,
+			Message:  fmt.Sprintf("%s outside loop", p.curToken.Literal),
+			Pos:      p.curToken.Pos,
+			End:      p.curToken.End,
+		})
+		return nil
+	}
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
 func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
 	stmt := &ast.ExpressionStatement{Token: p.curToken}
 
@@ -294,9 +614,40 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 	lit := &ast.IntegerLiteral{Token: p.curToken}
 
 	value, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
+	if errors.Is(err, strconv.ErrRange) {
+		if value, ok := new(big.Int).SetString(p.curToken.Literal, 0); ok {
+			lit.Big = value
+			return lit
+		}
+	}
 	if err != nil {
-		msg := fmt.Sprintf("could not parse %q as integer", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
+		p.addDiagnostic(Diagnostic{
+			Severity: SeverityError,
+			Code:     CodeInvalidInteger,
+			Message:  fmt.Sprintf("could not parse %q as integer", p.curToken.Literal),
+			Pos:      p.curToken.Pos,
+			End:      p.curToken.End,
+		})
+		return nil
+	}
+
+	lit.Value = value
+
+	return lit
+}
+
+func (p *Parser) parseFloatLiteral() ast.Expression {
+	lit := &ast.FloatLiteral{Token: p.curToken}
+
+	value, err := strconv.ParseFloat(p.curToken.Literal, 64)
+	if err != nil {
+		p.addDiagnostic(Diagnostic{
+			Severity: SeverityError,
+			Code:     CodeInvalidFloat,
+			Message:  fmt.Sprintf("could not parse %q as float", p.curToken.Literal),
+			Pos:      p.curToken.Pos,
+			End:      p.curToken.End,
+		})
 		return nil
 	}
 
@@ -326,12 +677,48 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 	}
 
 	precedence := p.curPrecedence()
+	if p.curTokenIs(token.POWER) {
+		// right associative: 2 ** 3 ** 2 is 2 ** (3 ** 2)
+		precedence--
+	}
 	p.nextToken()
 	expression.Right = p.parseExpression(precedence)
 
 	return expression
 }
 
+func (p *Parser) parseAssignExpression(left ast.Expression) ast.Expression {
+	expression := &ast.AssignExpression{
+		Token:    p.curToken,
+		Operator: p.curToken.Literal,
+		Target:   left,
+	}
+
+	if left == nil || p.panicking {
+		// the target, or part of it, already failed to parse and was reported
+		return nil
+	}
+	switch left.(type) {
+	case *ast.Identifier, *ast.IndexExpression:
+	default:
+		p.addDiagnostic(Diagnostic{
+			Severity: SeverityError,
+			Code:     CodeInvalidAssignment,
+			Message:  fmt.Sprintf("cannot assign to %s", left.String()),
+			Pos:      left.Pos(),
+			End:      left.End(),
+			Hint:     "only variables, array elements and hash entries can be assigned to",
+		})
+		return nil
+	}
+
+	// right associative: x = y = 1 is x = (y = 1)
+	p.nextToken()
+	expression.Value = p.parseExpression(ASSIGN - 1)
+
+	return expression
+}
+
 func (p *Parser) parseBoolean() ast.Expression {
 	return &ast.Boolean{Token: p.curToken, Value: p.curTokenIs(token.TRUE)}
 }
@@ -371,6 +758,22 @@ func (p *Parser) parseIfExpression() ast.Expression {
 	if p.peekTokenIs(token.ELSE) {
 		p.nextToken()
 
+		if p.peekTokenIs(token.IF) {
+			// else if: the alternative is a block holding just the nested if
+			p.nextToken()
+			block := &ast.BlockStatement{Token: p.curToken}
+			stmt := &ast.ExpressionStatement{Token: p.curToken}
+			nested := p.parseIfExpression()
+			if nested == nil {
+				return nil
+			}
+			stmt.Expression = nested
+			block.Statements = []ast.Statement{stmt}
+			block.EndPos = nested.End()
+			expression.Alternative = block
+			return expression
+		}
+
 		if !p.expectPeek(token.LBRACE) {
 			return nil
 		}
@@ -381,71 +784,379 @@ func (p *Parser) parseIfExpression() ast.Expression {
 	return expression
 }
 
+func (p *Parser) parseMatchExpression() ast.Expression {
+	expression := &ast.MatchExpression{Token: p.curToken}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	p.nextToken()
+	expression.Subject = p.parseExpression(LOWEST)
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	for !p.peekTokenIs(token.RBRACE) {
+		p.nextToken()
+
+		arm := &ast.MatchArm{Pattern: p.parsePattern()}
+		if arm.Pattern == nil {
+			return nil
+		}
+
+		if !p.expectPeek(token.ARROW) {
+			return nil
+		}
+
+		p.nextToken()
+		arm.Body = p.parseExpression(LOWEST)
+
+		expression.Arms = append(expression.Arms, arm)
+
+		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+
+	if !p.expectPeek(token.RBRACE) {
+		return nil
+	}
+
+	expression.EndPos = p.curToken.End
+
+	return expression
+}
+
+// parsePattern parses the pattern starting at curToken: a literal, _, a
+// name to bind, or an array or hash of patterns.
+func (p *Parser) parsePattern() ast.Pattern {
+	switch p.curToken.Type {
+	case token.IDENT:
+		if p.curToken.Literal == "_" {
+			return &ast.WildcardPattern{Token: p.curToken}
+		}
+		return &ast.BindingPattern{
+			Name: &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal},
+		}
+	case token.LBRACKET:
+		return p.parseArrayPattern()
+	case token.LBRACE:
+		return p.parseHashPattern()
+	default:
+		if lit := p.parseLiteralPattern(); lit != nil {
+			return lit
+		}
+		return nil
+	}
+}
+
+func (p *Parser) parseLiteralPattern() *ast.LiteralPattern {
+	switch p.curToken.Type {
+	case token.INT, token.FLOAT, token.STRING, token.TRUE, token.FALSE:
+	case token.MINUS:
+		if !p.peekTokenIs(token.INT) && !p.peekTokenIs(token.FLOAT) {
+			p.invalidPatternError(p.peekToken)
+			return nil
+		}
+	default:
+		p.invalidPatternError(p.curToken)
+		return nil
+	}
+
+	value := p.prefixParseFns[p.curToken.Type]()
+	if value == nil {
+		return nil
+	}
+
+	return &ast.LiteralPattern{Value: value}
+}
+
+func (p *Parser) parseArrayPattern() ast.Pattern {
+	pattern := &ast.ArrayPattern{Token: p.curToken}
+
+	for !p.peekTokenIs(token.RBRACKET) {
+		p.nextToken()
+
+		if p.curTokenIs(token.ELLIPSIS) {
+			// ...rest must come last
+			if !p.expectPeek(token.IDENT) {
+				return nil
+			}
+			pattern.Rest = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+			break
+		}
+
+		element := p.parsePattern()
+		if element == nil {
+			return nil
+		}
+		pattern.Elements = append(pattern.Elements, element)
+
+		if !p.peekTokenIs(token.RBRACKET) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+
+	if !p.expectPeek(token.RBRACKET) {
+		return nil
+	}
+
+	pattern.EndPos = p.curToken.End
+
+	return pattern
+}
+
+func (p *Parser) parseHashPattern() ast.Pattern {
+	pattern := &ast.HashPattern{Token: p.curToken}
+
+	for !p.peekTokenIs(token.RBRACE) {
+		p.nextToken()
+
+		var key *ast.LiteralPattern
+		var value ast.Pattern
+
+		if p.curTokenIs(token.IDENT) {
+			name := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+			key = &ast.LiteralPattern{
+				Value: &ast.StringLiteral{Token: p.curToken, Value: name.Value},
+			}
+			value = &ast.BindingPattern{Name: name}
+		} else {
+			key = p.parseLiteralPattern()
+			if key == nil {
+				return nil
+			}
+		}
+
+		if value == nil || p.peekTokenIs(token.COLON) {
+			if !p.expectPeek(token.COLON) {
+				return nil
+			}
+
+			p.nextToken()
+			value = p.parsePattern()
+			if value == nil {
+				return nil
+			}
+		}
+
+		pattern.Pairs = append(pattern.Pairs, &ast.HashPatternPair{Key: key, Value: value})
+
+		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+
+	if !p.expectPeek(token.RBRACE) {
+		return nil
+	}
+
+	pattern.EndPos = p.curToken.End
+
+	return pattern
+}
+
+func (p *Parser) invalidPatternError(tok token.Token) {
+	p.addDiagnostic(Diagnostic{
+		Severity: SeverityError,
+		Code:     CodeInvalidPattern,
+		Message:  fmt.Sprintf("expected a pattern, got %s instead", tok.Type),
+		Pos:      tok.Pos,
+		End:      tok.End,
+		Hint:     "patterns are literals, _, names, or arrays and hashes of patterns",
+	})
+}
+
 func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	block := &ast.BlockStatement{Token: p.curToken}
 	block.Statements = []ast.Statement{}
 
+	outerBlockDepth := p.blockDepth
+	p.blockDepth = p.braceDepth
+	defer func() { p.blockDepth = outerBlockDepth }()
+
 	p.nextToken()
 
 	for !p.curTokenIs(token.RBRACE) && !p.curTokenIs(token.EOF) {
-		stmt := p.parseStatement()
+		stmt := p.parseStatementWithRecovery()
 		if stmt != nil {
 			block.Statements = append(block.Statements, stmt)
 		}
+		if p.braceDepth < p.blockDepth {
+			// recovery stopped on the } closing this block
+			break
+		}
 		p.nextToken()
 	}
 
+	block.EndPos = p.curToken.End
+
 	return block
 }
 
 func (p *Parser) parseFunctionLiteral() ast.Expression {
 	lit := &ast.FunctionLiteral{Token: p.curToken}
 
-	if !p.expectPeek(token.LPAREN) {
+	if !p.parseFunction(lit) {
 		return nil
 	}
 
-	lit.Parameters = p.parseFunctionParameters()
+	return lit
+}
+
+// parseFunctionStatement parses a declaration, `fn name(params) { body }`.
+func (p *Parser) parseFunctionStatement() ast.Statement {
+	stmt := &ast.FunctionStatement{Token: p.curToken}
+	lit := &ast.FunctionLiteral{Token: p.curToken}
 
-	if !p.expectPeek(token.LBRACE) {
+	p.nextToken()
+	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	lit.Name = stmt.Name.Value
+
+	if !p.parseFunction(lit) {
 		return nil
 	}
+	stmt.Function = lit
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+// parseFunction parses the parameters and body of lit, starting with the
+// ( as peekToken.
+func (p *Parser) parseFunction(lit *ast.FunctionLiteral) bool {
+	if !p.expectPeek(token.LPAREN) {
+		return false
+	}
+
+	if !p.parseFunctionParameters(lit) {
+		return false
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return false
+	}
+
+	// break and continue cannot cross a function boundary
+	outerLoopDepth := p.loopDepth
+	p.loopDepth = 0
+	defer func() { p.loopDepth = outerLoopDepth }()
 
 	lit.Body = p.parseBlockStatement()
+	markTailCalls(lit.Body, true)
 
-	return lit
+	return true
 }
 
-func (p *Parser) parseFunctionParameters() []*ast.Identifier {
-	identifiers := []*ast.Identifier{}
+// markTailCalls flags the calls in tail position in a function body, whose
+// value becomes the value of the function: the value of a return, and the
+// last expression of the body, looking into both arms of if and the arms of
+// match. tail tells whether the value of block is the value of the function.
+func markTailCalls(block *ast.BlockStatement, tail bool) {
+	if block == nil {
+		return
+	}
 
-	if p.peekTokenIs(token.RPAREN) {
-		p.nextToken()
-		return identifiers
+	for i, stmt := range block.Statements {
+		last := tail && i == len(block.Statements)-1
+
+		switch stmt := stmt.(type) {
+		case *ast.ReturnStatement:
+			markTailExpression(stmt.ReturnValue, true)
+		case *ast.ExpressionStatement:
+			markTailExpression(stmt.Expression, last)
+		case *ast.WhileStatement:
+			markTailCalls(stmt.Body, false)
+		case *ast.ForStatement:
+			markTailCalls(stmt.Body, false)
+		}
 	}
+}
 
-	p.nextToken()
+// markTailExpression flags exp if it is a call in tail position, and
+// otherwise looks for returns inside it.
+func markTailExpression(exp ast.Expression, tail bool) {
+	switch exp := exp.(type) {
+	case *ast.CallExpression:
+		exp.Tail = tail
+	case *ast.IfExpression:
+		markTailCalls(exp.Consequence, tail)
+		markTailCalls(exp.Alternative, tail)
+	case *ast.MatchExpression:
+		for _, arm := range exp.Arms {
+			markTailExpression(arm.Body, tail)
+		}
+	}
+}
 
-	ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-	identifiers = append(identifiers, ident)
+// parseFunctionParameters parses the parameter list of lit: names, each
+// optionally followed by = and a default value, then an optional ...rest.
+// Once a parameter has a default, the following ones need one too.
+func (p *Parser) parseFunctionParameters(lit *ast.FunctionLiteral) bool {
+	lit.Parameters = []*ast.Identifier{}
 
-	for p.peekTokenIs(token.COMMA) {
-		p.nextToken()
+	if p.peekTokenIs(token.RPAREN) {
 		p.nextToken()
-		ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-		identifiers = append(identifiers, ident)
+		return true
 	}
 
-	if !p.expectPeek(token.RPAREN) {
-		return nil
+	for {
+		if p.peekTokenIs(token.ELLIPSIS) {
+			p.nextToken()
+			if !p.expectPeek(token.IDENT) {
+				return false
+			}
+			// the rest parameter must come last, expecting ) enforces it
+			lit.Rest = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+			break
+		}
+
+		if !p.expectPeek(token.IDENT) {
+			return false
+		}
+		ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+		lit.Parameters = append(lit.Parameters, ident)
+
+		if p.peekTokenIs(token.ASSIGN) {
+			p.nextToken()
+			p.nextToken()
+			if lit.Defaults == nil {
+				lit.Defaults = make(map[string]ast.Expression)
+			}
+			lit.Defaults[ident.Value] = p.parseExpression(LOWEST)
+		} else if len(lit.Defaults) > 0 {
+			p.addDiagnostic(Diagnostic{
+				Severity: SeverityError,
+				Code:     CodeInvalidParameter,
+				Message:  fmt.Sprintf("parameter %s without a default follows a parameter with one", ident.Value),
+				Pos:      ident.Pos(),
+				End:      ident.End(),
+			})
+			return false
+		}
+
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
+		p.nextToken()
 	}
 
-	return identifiers
+	return p.expectPeek(token.RPAREN)
 }
 
 func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
 	exp := &ast.CallExpression{Token: p.curToken, Function: function}
 	exp.Arguments = p.parseCallArguments()
+	exp.EndPos = p.curToken.End
 	return exp
 }
 
@@ -484,6 +1195,7 @@ func (p *Parser) registerInfix(tokenType token.TokenType, fn infixParseFn) {
 func (p *Parser) parseArrayLiteral() ast.Expression {
 	array := &ast.ArrayLiteral{Token: p.curToken}
 	array.Elements = p.parseExpressionList(token.RBRACKET)
+	array.EndPos = p.curToken.End
 	return array
 }
 
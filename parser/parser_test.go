@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"monkey/ast"
 	"monkey/lexer"
+	"monkey/token"
 	"testing"
 )
 
@@ -139,6 +140,103 @@ func TestIntegerLiteralExpression(t *testing.T) {
 	}
 }
 
+func TestIntegerLiteralForms(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"0xFF;", 255},
+		{"0o17;", 15},
+		{"0b1010;", 10},
+		{"1_000_000;", 1000000},
+		{"0x_dead_beef;", 0xdeadbeef},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		literal, ok := stmt.Expression.(*ast.IntegerLiteral)
+		if !ok {
+			t.Fatalf("exp not *ast.IntegerLiteral. got=%T", stmt.Expression)
+		}
+		if literal.Value != tt.expected {
+			t.Errorf("%s: literal.Value not %d. got=%d", tt.input, tt.expected, literal.Value)
+		}
+	}
+}
+
+func TestOversizeIntegerLiteral(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"9223372036854775808;", "9223372036854775808"},
+		{"123456789012345678901234567890;", "123456789012345678901234567890"},
+		{"0xFFFF_FFFF_FFFF_FFFF_FF;", "4722366482869645213695"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		literal, ok := stmt.Expression.(*ast.IntegerLiteral)
+		if !ok {
+			t.Fatalf("exp not *ast.IntegerLiteral. got=%T", stmt.Expression)
+		}
+		if literal.Big == nil {
+			t.Fatalf("%s: literal.Big is nil", tt.input)
+		}
+		if literal.Big.String() != tt.expected {
+			t.Errorf("%s: literal.Big not %s. got=%s", tt.input, tt.expected, literal.Big)
+		}
+	}
+}
+
+func TestFloatLiteralExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"3.14;", 3.14},
+		{"1e3;", 1000},
+		{"2.5E-1;", 0.25},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		literal, ok := stmt.Expression.(*ast.FloatLiteral)
+		if !ok {
+			t.Fatalf("exp not *ast.FloatLiteral. got=%T", stmt.Expression)
+		}
+		if literal.Value != tt.expected {
+			t.Errorf("literal.Value not %g. got=%g", tt.expected, literal.Value)
+		}
+	}
+}
+
+func TestInvalidFloatLiteral(t *testing.T) {
+	l := lexer.New("1e400;")
+	p := New(l)
+	p.ParseProgram()
+
+	diagnostics := p.Diagnostics()
+	if len(diagnostics) != 1 || diagnostics[0].Code != CodeInvalidFloat {
+		t.Fatalf("expected one %s diagnostic, got=%v", CodeInvalidFloat, diagnostics)
+	}
+}
+
 func TestParsingPrefixExpressions(t *testing.T) {
 	prefixTests := []struct {
 		input    string
@@ -151,6 +249,7 @@ func TestParsingPrefixExpressions(t *testing.T) {
 		{"-foobar;", "-", "foobar"},
 		{"!true;", "!", true},
 		{"!false;", "!", false},
+		{"~5;", "~", 5},
 	}
 
 	for _, tt := range prefixTests {
@@ -197,6 +296,17 @@ func TestParsingInfixExpressions(t *testing.T) {
 		{"5 / 5;", 5, "/", 5},
 		{"5 > 5;", 5, ">", 5},
 		{"5 < 5;", 5, "<", 5},
+		{"5 >= 5;", 5, ">=", 5},
+		{"5 <= 5;", 5, "<=", 5},
+		{"true && false;", true, "&&", false},
+		{"a || b;", "a", "||", "b"},
+		{"5 % 5;", 5, "%", 5},
+		{"5 ** 5;", 5, "**", 5},
+		{"5 & 5;", 5, "&", 5},
+		{"5 | 5;", 5, "|", 5},
+		{"5 ^ 5;", 5, "^", 5},
+		{"5 << 5;", 5, "<<", 5},
+		{"5 >> 5;", 5, ">>", 5},
 		{"5 == 5;", 5, "==", 5},
 		{"5 != 5;", 5, "!=", 5},
 		{"foobar + barfoo;", "foobar", "+", "barfoo"},
@@ -341,6 +451,74 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"add(a + b + c * d / f + g)",
 			"add((((a + b) + ((c * d) / f)) + g))",
 		},
+		{
+			"a * b % c",
+			"((a * b) % c)",
+		},
+		{
+			"2 ** 3 ** 2",
+			"(2 ** (3 ** 2))",
+		},
+		{
+			"-2 ** 2",
+			"(-(2 ** 2))",
+		},
+		{
+			"a * b ** c",
+			"(a * (b ** c))",
+		},
+		{
+			"a | b ^ c & d",
+			"(a | (b ^ (c & d)))",
+		},
+		{
+			"a & b << c + d",
+			"(a & (b << (c + d)))",
+		},
+		{
+			"a & b == c",
+			"((a & b) == c)",
+		},
+		{
+			"~a & b",
+			"((~a) & b)",
+		},
+		{
+			"a < b | c && d",
+			"((a < (b | c)) && d)",
+		},
+		{
+			"a || b && c",
+			"(a || (b && c))",
+		},
+		{
+			"a && b || c && d",
+			"((a && b) || (c && d))",
+		},
+		{
+			"a == b && c != d",
+			"((a == b) && (c != d))",
+		},
+		{
+			"!a && b < c || -d",
+			"(((!a) && (b < c)) || (-d))",
+		},
+		{
+			"!(a || b)",
+			"(!(a || b))",
+		},
+		{
+			"5 >= 4 == 3 <= 4",
+			"((5 >= 4) == (3 <= 4))",
+		},
+		{
+			"a + b <= c * d",
+			"((a + b) <= (c * d))",
+		},
+		{
+			"-a >= !b",
+			"((-a) >= (!b))",
+		},
 		{
 			"a * [1, 2, 3, 4][b * c] * d",
 			"((a * ([1, 2, 3, 4][(b * c)])) * d)",
@@ -349,6 +527,26 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"add(a * b[2], b[1], 2 * [1, 2][1])",
 			"add((a * (b[2])), (b[1]), (2 * ([1, 2][1])))",
 		},
+		{
+			"x = y = a + b",
+			"(x = (y = (a + b)))",
+		},
+		{
+			"x += a || b && c",
+			"(x += (a || (b && c)))",
+		},
+		{
+			"f(x *= 2, y)",
+			"f((x *= 2), y)",
+		},
+		{
+			"a[i + 1] = b[i] * 2",
+			"((a[(i + 1)]) = ((b[i]) * 2))",
+		},
+		{
+			`h["k"][0] += 1`,
+			"(((h[k])[0]) += 1)",
+		},
 	}
 
 	for _, tt := range tests {
@@ -824,6 +1022,36 @@ func checkParserErrors(t *testing.T, p *Parser) {
 	t.FailNow()
 }
 
+// diagnosticStrings returns the diagnostics of p in their positioned form.
+func diagnosticStrings(p *Parser) []string {
+	strs := make([]string, 0, len(p.Diagnostics()))
+	for _, d := range p.Diagnostics() {
+		strs = append(strs, d.String())
+	}
+	return strs
+}
+
+func TestErrorsAreBareMessages(t *testing.T) {
+	l := lexer.New("let = 5; let x 5;")
+	p := New(l)
+	p.ParseProgram()
+
+	expected := []string{
+		"expected next token to be IDENT, got = instead",
+		"expected next token to be =, got INT instead",
+	}
+
+	errors := p.Errors()
+	if len(errors) != len(expected) {
+		t.Fatalf("wrong number of errors. expected=%q, got=%q", expected, errors)
+	}
+	for i, msg := range expected {
+		if errors[i] != msg {
+			t.Errorf("wrong error %d. expected=%q, got=%q", i, msg, errors[i])
+		}
+	}
+}
+
 func TestStringLiteral(t *testing.T) {
 	input := `"hello world";`
 
@@ -952,3 +1180,919 @@ func TestParseEmptyHashLiteral(t *testing.T) {
 		t.Errorf("hash.Pairs has wrong length. got=%d", len(hash.Pairs))
 	}
 }
+
+func TestNodeSpans(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedStart string
+		expectedEnd   string
+	}{
+		{"a + b * c", "1:1", "1:10"},
+		{"-foo", "1:1", "1:5"},
+		{"add(1,\n 2)", "1:1", "2:4"},
+		{"myArray[1 + 1]", "1:1", "1:15"},
+		{"[1, 2]", "1:1", "1:7"},
+		{`{"a": 1}`, "1:1", "1:9"},
+		{"if (x) { y } else { z }", "1:1", "1:24"},
+		{"fn(x) {\n  x\n}", "1:1", "3:2"},
+		{"let x = 5 * 5;", "1:1", "1:14"},
+		{"return x;", "1:1", "1:9"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0]
+		if stmt.Pos().String() != tt.expectedStart {
+			t.Errorf("%q: Pos() wrong. expected=%s, got=%s",
+				tt.input, tt.expectedStart, stmt.Pos())
+		}
+		if stmt.End().String() != tt.expectedEnd {
+			t.Errorf("%q: End() wrong. expected=%s, got=%s",
+				tt.input, tt.expectedEnd, stmt.End())
+		}
+	}
+}
+
+func TestParserErrorPositions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let = 5;", "1:5: expected next token to be IDENT, got = instead"},
+		{"let x 5;", "1:7: expected next token to be =, got INT instead"},
+		{"5;\n  }", "2:3: no prefix parse function for } found"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		errors := diagnosticStrings(p)
+		if len(errors) == 0 {
+			t.Fatalf("%q: expected parser errors, got none", tt.input)
+		}
+		if errors[0] != tt.expected {
+			t.Errorf("%q: wrong error. expected=%q, got=%q",
+				tt.input, tt.expected, errors[0])
+		}
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	input := "let x = (1 + 2;"
+
+	l := lexer.New(input)
+	p := New(l)
+	p.ParseProgram()
+
+	diagnostics := p.Diagnostics()
+	if len(diagnostics) != 1 {
+		t.Fatalf("expected 1 diagnostic, got=%d (%v)", len(diagnostics), diagnostics)
+	}
+
+	d := diagnostics[0]
+	if d.Severity != SeverityError {
+		t.Errorf("d.Severity wrong. got=%s", d.Severity)
+	}
+	if d.Code != CodeUnexpectedToken {
+		t.Errorf("d.Code wrong. got=%q", d.Code)
+	}
+	if d.Expected != token.RPAREN || d.Got != token.SEMICOLON {
+		t.Errorf("d.Expected/d.Got wrong. got=%q/%q", d.Expected, d.Got)
+	}
+	if d.Pos.String() != "1:15" || d.End.String() != "1:16" {
+		t.Errorf("d span wrong. got=%s-%s", d.Pos, d.End)
+	}
+	if d.Hint == "" {
+		t.Errorf("d.Hint is empty")
+	}
+}
+
+func TestDiagnosticRender(t *testing.T) {
+	d := Diagnostic{
+		Severity: SeverityError,
+		Code:     CodeUnexpectedToken,
+		Message:  "something is off",
+		Pos:      token.Position{Line: 2, Column: 6, RuneColumn: 6},
+		End:      token.Position{Line: 2, Column: 10, RuneColumn: 9},
+		Hint:     "try harder",
+	}
+
+	source := "let a = 1;\n\tlet föo = 2;"
+	expected := "2:6: error: something is off\n" +
+		"\tlet föo = 2;\n" +
+		"\t    ^^^\n" +
+		"hint: try harder\n"
+
+	if got := d.Render(source); got != expected {
+		t.Errorf("Render wrong.\nexpected=%q\ngot=%q", expected, got)
+	}
+}
+
+func TestErrorRecovery(t *testing.T) {
+	tests := []struct {
+		input              string
+		expectedErrors     []string
+		expectedStatements []string
+	}{
+		{
+			"let x = (1 + 2; let y = 3;",
+			[]string{"1:15: expected next token to be ), got ; instead"},
+			[]string{"<bad statement>", "let y = 3;"},
+		},
+		{
+			"let = 5; let y = 3; return y;",
+			[]string{"1:5: expected next token to be IDENT, got = instead"},
+			[]string{"<bad statement>", "let y = 3;", "return y;"},
+		},
+		{
+			"add(1, 2\nlet y = 3;",
+			[]string{"2:1: expected next token to be ), got LET instead"},
+			[]string{"<bad statement>", "let y = 3;"},
+		},
+		{
+			"if (x { y } let z = 1;",
+			[]string{"1:7: expected next token to be ), got { instead"},
+			[]string{"<bad statement>", "let z = 1;"},
+		},
+		{
+			`let h = {"a" 1, "b": 2}; h;`,
+			[]string{"1:14: expected next token to be :, got INT instead"},
+			[]string{"<bad statement>", "h"},
+		},
+		{
+			"let f = fn() { let = 1; 2 }; f;",
+			[]string{"1:20: expected next token to be IDENT, got = instead"},
+			[]string{"let f = fn() <bad statement>2;", "f"},
+		},
+		{
+			"let f = fn() { let x = }; f;",
+			[]string{"1:24: no prefix parse function for } found"},
+			[]string{"let f = fn() <bad statement>;", "f"},
+		},
+		{
+			"let a = ; let b = *; let c = 3;",
+			[]string{
+				"1:9: no prefix parse function for ; found",
+				"1:19: no prefix parse function for * found",
+			},
+			[]string{"<bad statement>", "<bad statement>", "let c = 3;"},
+		},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+
+		errors := diagnosticStrings(p)
+		if len(errors) != len(tt.expectedErrors) {
+			t.Errorf("%q: wrong number of errors. expected=%q, got=%q",
+				tt.input, tt.expectedErrors, errors)
+			continue
+		}
+		for i, msg := range tt.expectedErrors {
+			if errors[i] != msg {
+				t.Errorf("%q: wrong error. expected=%q, got=%q",
+					tt.input, msg, errors[i])
+			}
+		}
+
+		if len(program.Statements) != len(tt.expectedStatements) {
+			t.Errorf("%q: wrong number of statements. expected=%d, got=%d (%q)",
+				tt.input, len(tt.expectedStatements), len(program.Statements),
+				program.String())
+			continue
+		}
+		for i, expected := range tt.expectedStatements {
+			if got := program.Statements[i].String(); got != expected {
+				t.Errorf("%q: statement %d wrong. expected=%q, got=%q",
+					tt.input, i, expected, got)
+			}
+		}
+	}
+}
+
+func TestBadStatementSpan(t *testing.T) {
+	input := "let x = (1 + 2; let y = 3;"
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+
+	bad, ok := program.Statements[0].(*ast.BadStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.BadStatement. got=%T",
+			program.Statements[0])
+	}
+	if bad.Pos().String() != "1:1" || bad.End().String() != "1:16" {
+		t.Errorf("bad statement span wrong. got=%s-%s", bad.Pos(), bad.End())
+	}
+}
+
+func TestParsingWithComments(t *testing.T) {
+	input := `// answer
+let x = /* inline */ 42; // trailing`
+
+	l := lexer.New(input)
+	l.EmitComments(true)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain 1 statements. got=%d",
+			len(program.Statements))
+	}
+
+	if program.String() != "let x = 42;" {
+		t.Errorf("program.String() wrong. got=%q", program.String())
+	}
+}
+
+func TestIllegalTokenDiagnostic(t *testing.T) {
+	input := "let x = 1; /* oops"
+
+	l := lexer.New(input)
+	p := New(l)
+	p.ParseProgram()
+
+	diagnostics := p.Diagnostics()
+	if len(diagnostics) != 1 {
+		t.Fatalf("expected 1 diagnostic, got=%d (%v)", len(diagnostics), diagnostics)
+	}
+
+	d := diagnostics[0]
+	if d.Code != CodeIllegalToken || d.Message != "unterminated block comment" {
+		t.Errorf("wrong diagnostic. got=%+v", d)
+	}
+	if d.Pos.String() != "1:12" || d.End.String() != "1:19" {
+		t.Errorf("d span wrong. got=%s-%s", d.Pos, d.End)
+	}
+}
+
+func TestStringLiteralDiagnostics(t *testing.T) {
+	tests := []struct {
+		input       string
+		expectedMsg string
+		expectedPos string
+	}{
+		{`let s = "a\qb"; let t = 1;`, `unknown escape sequence \q`, "1:11"},
+		{`let s = "abc`, "unterminated string", "1:9"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		diagnostics := p.Diagnostics()
+		if len(diagnostics) != 1 {
+			t.Fatalf("%s: expected 1 diagnostic, got=%d (%v)",
+				tt.input, len(diagnostics), diagnostics)
+		}
+
+		d := diagnostics[0]
+		if d.Code != CodeIllegalToken || d.Message != tt.expectedMsg {
+			t.Errorf("%s: wrong diagnostic. got=%+v", tt.input, d)
+		}
+		if d.Pos.String() != tt.expectedPos {
+			t.Errorf("%s: wrong position. expected=%s, got=%s",
+				tt.input, tt.expectedPos, d.Pos)
+		}
+	}
+}
+
+func TestAssignExpression(t *testing.T) {
+	tests := []struct {
+		input            string
+		expectedName     string
+		expectedOperator string
+		expectedValue    interface{}
+	}{
+		{"x = 5;", "x", "=", 5},
+		{"total += 1;", "total", "+=", 1},
+		{"n -= m;", "n", "-=", "m"},
+		{"n *= 2;", "n", "*=", 2},
+		{"n /= 2;", "n", "/=", 2},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not contain 1 statements. got=%d",
+				len(program.Statements))
+		}
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		exp, ok := stmt.Expression.(*ast.AssignExpression)
+		if !ok {
+			t.Fatalf("exp not *ast.AssignExpression. got=%T", stmt.Expression)
+		}
+		if !testIdentifier(t, exp.Target, tt.expectedName) {
+			return
+		}
+		if exp.Operator != tt.expectedOperator {
+			t.Errorf("exp.Operator is not '%s'. got=%s", tt.expectedOperator, exp.Operator)
+		}
+		if !testLiteralExpression(t, exp.Value, tt.expectedValue) {
+			return
+		}
+	}
+}
+
+func TestInvalidAssignmentTarget(t *testing.T) {
+	tests := []struct {
+		input       string
+		expectedMsg string
+		expectedPos string
+	}{
+		{"5 = x;", "cannot assign to 5", "1:1"},
+		{"let y = a + b += 1;", "cannot assign to (a + b)", "1:9"},
+		{"f() = 2;", "cannot assign to f()", "1:1"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		diagnostics := p.Diagnostics()
+		if len(diagnostics) != 1 {
+			t.Fatalf("%s: expected 1 diagnostic, got=%d (%v)",
+				tt.input, len(diagnostics), diagnostics)
+		}
+
+		d := diagnostics[0]
+		if d.Code != CodeInvalidAssignment || d.Message != tt.expectedMsg {
+			t.Errorf("%s: wrong diagnostic. got=%+v", tt.input, d)
+		}
+		if d.Pos.String() != tt.expectedPos {
+			t.Errorf("%s: wrong position. expected=%s, got=%s",
+				tt.input, tt.expectedPos, d.Pos)
+		}
+	}
+
+	// targets only partly parsed were already reported and have nil operands
+	partial := []string{"!) = 1", "- ** += 1", "let y = -} = 2", "~ ] ="}
+
+	for _, input := range partial {
+		l := lexer.New(input)
+		p := New(l)
+		p.ParseProgram()
+
+		diagnostics := p.Diagnostics()
+		if len(diagnostics) == 0 {
+			t.Errorf("%s: expected diagnostics, got none", input)
+		}
+		for _, d := range diagnostics {
+			if d.Code == CodeInvalidAssignment {
+				t.Errorf("%s: unexpected diagnostic. got=%+v", input, d)
+			}
+		}
+	}
+}
+
+func TestWhileStatement(t *testing.T) {
+	input := `while (x < y) { x += 1; continue; }`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain 1 statements. got=%d",
+			len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.WhileStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.WhileStatement. got=%T",
+			program.Statements[0])
+	}
+
+	if !testInfixExpression(t, stmt.Condition, "x", "<", "y") {
+		return
+	}
+
+	if len(stmt.Body.Statements) != 2 {
+		t.Fatalf("body is not 2 statements. got=%d", len(stmt.Body.Statements))
+	}
+
+	if _, ok := stmt.Body.Statements[1].(*ast.ContinueStatement); !ok {
+		t.Errorf("body.Statements[1] is not ast.ContinueStatement. got=%T",
+			stmt.Body.Statements[1])
+	}
+
+	if stmt.End().String() != "1:36" {
+		t.Errorf("stmt.End() wrong. got=%s", stmt.End())
+	}
+}
+
+func TestForStatement(t *testing.T) {
+	input := `for (item in items) { if (item) { break; } }`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain 1 statements. got=%d",
+			len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.ForStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.ForStatement. got=%T",
+			program.Statements[0])
+	}
+
+	if !testIdentifier(t, stmt.Variable, "item") {
+		return
+	}
+	if !testIdentifier(t, stmt.Iterable, "items") {
+		return
+	}
+
+	if stmt.String() != "for (item in items) ifitem break;" {
+		t.Errorf("stmt.String() wrong. got=%q", stmt.String())
+	}
+}
+
+func TestLoopControlOutsideLoop(t *testing.T) {
+	tests := []struct {
+		input       string
+		expectedMsg string
+		expectedPos string
+	}{
+		{"break;", "break outside loop", "1:1"},
+		{"if (x) { continue; }", "continue outside loop", "1:10"},
+		{"while (x) { let f = fn() { break; }; }", "break outside loop", "1:28"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		diagnostics := p.Diagnostics()
+		if len(diagnostics) != 1 {
+			t.Fatalf("%s: expected 1 diagnostic, got=%d (%v)",
+				tt.input, len(diagnostics), diagnostics)
+		}
+
+		d := diagnostics[0]
+		if d.Code != CodeOutsideLoop || d.Message != tt.expectedMsg {
+			t.Errorf("%s: wrong diagnostic. got=%+v", tt.input, d)
+		}
+		if d.Pos.String() != tt.expectedPos {
+			t.Errorf("%s: wrong position. expected=%s, got=%s",
+				tt.input, tt.expectedPos, d.Pos)
+		}
+	}
+}
+
+func TestLoopControlInsideLoop(t *testing.T) {
+	input := `
+for (x in xs) {
+  let f = fn() { while (true) { break; } };
+  continue;
+}`
+
+	l := lexer.New(input)
+	p := New(l)
+	p.ParseProgram()
+	checkParserErrors(t, p)
+}
+
+func TestElseIfExpression(t *testing.T) {
+	input := `if (x < y) { x } else if (x > y) { y } else { z }`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain 1 statements. got=%d",
+			len(program.Statements))
+	}
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	exp, ok := stmt.Expression.(*ast.IfExpression)
+	if !ok {
+		t.Fatalf("stmt.Expression is not ast.IfExpression. got=%T", stmt.Expression)
+	}
+
+	if len(exp.Alternative.Statements) != 1 {
+		t.Fatalf("alternative is not 1 statements. got=%d", len(exp.Alternative.Statements))
+	}
+
+	alternative := exp.Alternative.Statements[0].(*ast.ExpressionStatement)
+	nested, ok := alternative.Expression.(*ast.IfExpression)
+	if !ok {
+		t.Fatalf("alternative is not ast.IfExpression. got=%T", alternative.Expression)
+	}
+
+	if !testInfixExpression(t, nested.Condition, "x", ">", "y") {
+		return
+	}
+	if nested.Alternative == nil {
+		t.Fatalf("nested.Alternative is nil")
+	}
+
+	if exp.End().String() != "1:50" {
+		t.Errorf("exp.End() wrong. got=%s", exp.End())
+	}
+}
+
+func TestMatchExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			`match (x) { 1 => "one", -2.5 => "neg", _ => "other" }`,
+			"match (x) { 1 => one, (-2.5) => neg, _ => other }",
+		},
+		{
+			`match (p) { [a, [b, _]] => a + b, {"k": true, 2: v} => v, }`,
+			"match (p) { [a, [b, _]] => (a + b), {k: true, 2: v} => v }",
+		},
+		{
+			"match (xs) { [x, ...rest] => x, {name, age: years} => years }",
+			"match (xs) { [x, ...rest] => x, {name: name, age: years} => years }",
+		},
+		{
+			"match (f(x)) { [] => 0, {} => 1 }",
+			"match (f(x)) { [] => 0, {} => 1 }",
+		},
+		{
+			"match (x) {}",
+			"match (x) {  }",
+		},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		if _, ok := stmt.Expression.(*ast.MatchExpression); !ok {
+			t.Fatalf("stmt.Expression is not ast.MatchExpression. got=%T", stmt.Expression)
+		}
+
+		if program.String() != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, program.String())
+		}
+	}
+}
+
+func TestInvalidMatchPatterns(t *testing.T) {
+	tests := []struct {
+		input       string
+		expectedMsg string
+		expectedPos string
+	}{
+		{"match (x) { a + 1 => 2 }", "expected next token to be =>, got + instead", "1:15"},
+		{"match (x) { (1) => 2 }", "expected a pattern, got ( instead", "1:13"},
+		{"match (x) { -a => 2 }", "expected a pattern, got IDENT instead", "1:14"},
+		{"match (x) { {[k]: 1} => 2 }", "expected a pattern, got [ instead", "1:14"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		diagnostics := p.Diagnostics()
+		if len(diagnostics) != 1 {
+			t.Fatalf("%s: expected 1 diagnostic, got=%d (%v)",
+				tt.input, len(diagnostics), diagnostics)
+		}
+
+		d := diagnostics[0]
+		if d.Message != tt.expectedMsg {
+			t.Errorf("%s: wrong message. expected=%q, got=%q", tt.input, tt.expectedMsg, d.Message)
+		}
+		if d.Pos.String() != tt.expectedPos {
+			t.Errorf("%s: wrong position. expected=%s, got=%s",
+				tt.input, tt.expectedPos, d.Pos)
+		}
+	}
+}
+
+func TestDestructuringLetStatements(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedPattern string
+	}{
+		{"let [a, b] = arr;", "[a, b]"},
+		{"let [first, ...rest] = f(x);", "[first, ...rest]"},
+		{"let [...all] = arr;", "[...all]"},
+		{"let [a, [b, _]] = arr;", "[a, [b, _]]"},
+		{"let {name, age: years} = h;", "{name: name, age: years}"},
+		{`let {"first name": first, 1: one} = h;`, "{first name: first, 1: one}"},
+		{"let {point: [x, y]} = h;", "{point: [x, y]}"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not contain 1 statements. got=%d",
+				len(program.Statements))
+		}
+
+		stmt, ok := program.Statements[0].(*ast.LetStatement)
+		if !ok {
+			t.Fatalf("s not *ast.LetStatement. got=%T", program.Statements[0])
+		}
+		if stmt.Name != nil {
+			t.Errorf("stmt.Name is not nil. got=%s", stmt.Name)
+		}
+		if stmt.Pattern == nil {
+			t.Fatalf("stmt.Pattern is nil")
+		}
+		if stmt.Pattern.String() != tt.expectedPattern {
+			t.Errorf("stmt.Pattern wrong. expected=%q, got=%q",
+				tt.expectedPattern, stmt.Pattern.String())
+		}
+	}
+}
+
+func TestInvalidDestructuringLetStatements(t *testing.T) {
+	tests := []struct {
+		input       string
+		expectedMsg string
+		expectedPos string
+	}{
+		{"let [...rest, last] = arr;", "expected next token to be ], got , instead", "1:13"},
+		{"let [...] = arr;", "expected next token to be IDENT, got ] instead", "1:9"},
+		{"let {a b} = h;", "expected next token to be ,, got IDENT instead", "1:8"},
+		{"let [a] h;", "expected next token to be =, got IDENT instead", "1:9"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		diagnostics := p.Diagnostics()
+		if len(diagnostics) != 1 {
+			t.Fatalf("%s: expected 1 diagnostic, got=%d (%v)",
+				tt.input, len(diagnostics), diagnostics)
+		}
+
+		d := diagnostics[0]
+		if d.Message != tt.expectedMsg {
+			t.Errorf("%s: wrong message. expected=%q, got=%q", tt.input, tt.expectedMsg, d.Message)
+		}
+		if d.Pos.String() != tt.expectedPos {
+			t.Errorf("%s: wrong position. expected=%s, got=%s",
+				tt.input, tt.expectedPos, d.Pos)
+		}
+	}
+}
+
+func TestFunctionDefaultAndRestParameters(t *testing.T) {
+	tests := []struct {
+		input            string
+		expectedParams   []string
+		expectedDefaults map[string]string
+		expectedRest     string
+	}{
+		{"fn(x, y = 10) {};", []string{"x", "y"}, map[string]string{"y": "10"}, ""},
+		{"fn(x = 1, y = x * 2) {};", []string{"x", "y"}, map[string]string{"x": "1", "y": "(x * 2)"}, ""},
+		{"fn(head, ...tail) {};", []string{"head"}, map[string]string{}, "tail"},
+		{"fn(...args) {};", []string{}, map[string]string{}, "args"},
+		{"fn(a, b = 2, ...c) {};", []string{"a", "b"}, map[string]string{"b": "2"}, "c"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		function := stmt.Expression.(*ast.FunctionLiteral)
+
+		if len(function.Parameters) != len(tt.expectedParams) {
+			t.Fatalf("%s: length parameters wrong. want %d, got=%d",
+				tt.input, len(tt.expectedParams), len(function.Parameters))
+		}
+		for i, ident := range tt.expectedParams {
+			testLiteralExpression(t, function.Parameters[i], ident)
+		}
+
+		if len(function.Defaults) != len(tt.expectedDefaults) {
+			t.Errorf("%s: length defaults wrong. want %d, got=%d",
+				tt.input, len(tt.expectedDefaults), len(function.Defaults))
+		}
+		for name, expected := range tt.expectedDefaults {
+			def, ok := function.Defaults[name]
+			if !ok || def.String() != expected {
+				t.Errorf("%s: default of %s wrong. want %q, got=%v", tt.input, name, expected, def)
+			}
+		}
+
+		if tt.expectedRest == "" {
+			if function.Rest != nil {
+				t.Errorf("%s: function.Rest is not nil. got=%s", tt.input, function.Rest)
+			}
+		} else if function.Rest == nil || function.Rest.Value != tt.expectedRest {
+			t.Errorf("%s: function.Rest wrong. want %s, got=%v", tt.input, tt.expectedRest, function.Rest)
+		}
+	}
+}
+
+func TestFunctionLiteralWithName(t *testing.T) {
+	input := `let myFunction = fn() { };`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.LetStatement)
+	function, ok := stmt.Value.(*ast.FunctionLiteral)
+	if !ok {
+		t.Fatalf("stmt.Value is not ast.FunctionLiteral. got=%T", stmt.Value)
+	}
+
+	if function.Name != "myFunction" {
+		t.Errorf("function literal name wrong. want 'myFunction', got=%q", function.Name)
+	}
+}
+
+func TestInvalidFunctionParameters(t *testing.T) {
+	tests := []struct {
+		input       string
+		expectedMsg string
+		expectedPos string
+	}{
+		{"fn(x = 1, y) {}", "parameter y without a default follows a parameter with one", "1:11"},
+		{"fn(...rest, x) {}", "expected next token to be ), got , instead", "1:11"},
+		{"fn(...rest = 1) {}", "expected next token to be ), got = instead", "1:12"},
+		{"fn(1) {}", "expected next token to be IDENT, got INT instead", "1:4"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		p.ParseProgram()
+
+		diagnostics := p.Diagnostics()
+		if len(diagnostics) != 1 {
+			t.Fatalf("%s: expected 1 diagnostic, got=%d (%v)",
+				tt.input, len(diagnostics), diagnostics)
+		}
+
+		d := diagnostics[0]
+		if d.Message != tt.expectedMsg {
+			t.Errorf("%s: wrong message. expected=%q, got=%q", tt.input, tt.expectedMsg, d.Message)
+		}
+		if d.Pos.String() != tt.expectedPos {
+			t.Errorf("%s: wrong position. expected=%s, got=%s",
+				tt.input, tt.expectedPos, d.Pos)
+		}
+	}
+}
+
+func TestFunctionStatement(t *testing.T) {
+	input := `fn add(x, y = 1) { x + y }`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain 1 statements. got=%d",
+			len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.FunctionStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.FunctionStatement. got=%T",
+			program.Statements[0])
+	}
+
+	if !testIdentifier(t, stmt.Name, "add") {
+		return
+	}
+	if stmt.Function.Name != "add" {
+		t.Errorf("function literal name wrong. want 'add', got=%q", stmt.Function.Name)
+	}
+	if len(stmt.Function.Parameters) != 2 {
+		t.Fatalf("function literal parameters wrong. want 2, got=%d",
+			len(stmt.Function.Parameters))
+	}
+	if stmt.String() != "fn add(x, y = 1) (x + y)" {
+		t.Errorf("stmt.String() wrong. got=%q", stmt.String())
+	}
+	if stmt.End().String() != "1:27" {
+		t.Errorf("stmt.End() wrong. got=%s", stmt.End())
+	}
+}
+
+func TestAnonymousFunctionStatementIsAnExpression(t *testing.T) {
+	input := `fn(x) { x }(5);`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.ExpressionStatement. got=%T",
+			program.Statements[0])
+	}
+	if _, ok := stmt.Expression.(*ast.CallExpression); !ok {
+		t.Fatalf("stmt.Expression is not ast.CallExpression. got=%T", stmt.Expression)
+	}
+}
+
+func TestTailCallMarking(t *testing.T) {
+	input := `
+fn f(n) {
+  g(1);
+  let x = g(2);
+  if (n) { return g(3); }
+  while (n) { g(4); return g(5); }
+  if (n) { g(6) } else { h(g(7)) }
+}`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	// the argument of g identifies each call; h wraps the last one
+	expected := map[string]bool{"1": false, "2": false, "3": true, "4": false, "5": true, "6": true, "7": false}
+
+	var visit func(node ast.Node)
+	visit = func(node ast.Node) {
+		switch node := node.(type) {
+		case *ast.Program:
+			for _, s := range node.Statements {
+				visit(s)
+			}
+		case *ast.FunctionStatement:
+			visit(node.Function.Body)
+		case *ast.BlockStatement:
+			for _, s := range node.Statements {
+				visit(s)
+			}
+		case *ast.ExpressionStatement:
+			visit(node.Expression)
+		case *ast.ReturnStatement:
+			visit(node.ReturnValue)
+		case *ast.LetStatement:
+			visit(node.Value)
+		case *ast.WhileStatement:
+			visit(node.Body)
+		case *ast.IfExpression:
+			visit(node.Consequence)
+			if node.Alternative != nil {
+				visit(node.Alternative)
+			}
+		case *ast.CallExpression:
+			if node.Function.String() == "h" {
+				if !node.Tail {
+					t.Errorf("call %s not marked as tail call", node)
+				}
+				visit(node.Arguments[0])
+				return
+			}
+			arg := node.Arguments[0].String()
+			if node.Tail != expected[arg] {
+				t.Errorf("call %s: Tail wrong. want=%t, got=%t", node, expected[arg], node.Tail)
+			}
+			delete(expected, arg)
+		}
+	}
+	visit(program)
+
+	if len(expected) != 0 {
+		t.Errorf("calls not visited: %v", expected)
+	}
+}
@@ -0,0 +1,49 @@
+package repl
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		source         string
+		expectedOk     bool
+		expectedOutput string
+	}{
+		{"let x = 1; x + 1;", true, ""},
+		{
+			`let add = fn(a, b) { a + b };
+fn twice(x) { add(x, x) }
+twice("a") + twice(true);`,
+			false,
+			`Traceback (most recent call last):
+  script.mk:3:14: in call to twice
+  script.mk:2:15: in call to add
+ERROR: script.mk:1:22: unknown operator: BOOLEAN + BOOLEAN
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		var out bytes.Buffer
+		ok := Run("script.mk", tt.source, &out)
+
+		if ok != tt.expectedOk {
+			t.Errorf("%q: wrong result. expected=%t, got=%t", tt.source, tt.expectedOk, ok)
+		}
+		if out.String() != tt.expectedOutput {
+			t.Errorf("%q: wrong output. expected=\n%s\ngot=\n%s", tt.source, tt.expectedOutput, out.String())
+		}
+	}
+}
+
+func TestRunReportsParserErrors(t *testing.T) {
+	var out bytes.Buffer
+	if Run("script.mk", "let = 1;", &out) {
+		t.Errorf("expected Run to fail on a parser error")
+	}
+	if !bytes.Contains(out.Bytes(), []byte("script.mk:1:5")) {
+		t.Errorf("parser error does not name the script. got=\n%s", out.String())
+	}
+}
@@ -0,0 +1,34 @@
+package token
+
+import "fmt"
+
+// Position is a location in the source code.
+// The zero value is an invalid position, used for nodes built by hand.
+type Position struct {
+	Filename   string // optional, empty when lexing a bare string
+	Offset     int    // byte offset, starting at 0
+	Line       int    // line number, starting at 1
+	Column     int    // column number, starting at 1 (byte count)
+	RuneColumn int    // column number, starting at 1 (rune count)
+}
+
+func (p Position) IsValid() bool {
+	return p.Line > 0
+}
+
+// String returns the position as "file:line:column", "line:column" when
+// there is no filename, or "-" when the position is invalid.
+func (p Position) String() string {
+	if !p.IsValid() {
+		if p.Filename != "" {
+			return p.Filename
+		}
+		return "-"
+	}
+
+	if p.Filename != "" {
+		return fmt.Sprintf("%s:%d:%d", p.Filename, p.Line, p.Column)
+	}
+
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}